@@ -0,0 +1,287 @@
+package arc
+
+import (
+	"container/list"
+	"github.com/thewisecirno/simple_distributed_cache/lru"
+	"time"
+)
+
+// Value 与lru.Value相同，方便不同的淘汰策略互相替换
+type Value = lru.Value
+
+// Cache 自适应替换缓存(Adaptive Replacement Cache)
+// t1保存只访问过一次的记录，t2保存访问过多次的记录，b1/b2分别记录最近从t1/t2淘汰的key(幽灵记录，不保存值)
+// 命中幽灵记录时调整t1的目标大小p，使缓存在"最近访问"和"经常访问"之间自适应，一次性扫描只会冲掉t1而不影响t2
+// 与原始算法按条数计算不同，这里的容量、p以及幽灵记录都按字节计算
+type Cache struct {
+	maxBytes int64
+	nowBytes int64
+	//t1的目标字节数
+	p int64
+
+	t1, t2           *list.List
+	b1, b2           *list.List
+	t1Bytes, t2Bytes int64
+	b1Bytes, b2Bytes int64
+	cache            map[string]*list.Element
+	ghosts           map[string]*list.Element
+
+	//某条记录被删除时的回调函数
+	OnEvicted func(key string, value Value)
+}
+
+type entry struct {
+	key string
+	val Value
+	//过期时间，零值表示永不过期
+	expire time.Time
+	inT2   bool
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.val.Len())
+}
+
+type ghost struct {
+	key  string
+	size int64
+	inB2 bool
+}
+
+func NewCache(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        list.New(),
+		t2:        list.New(),
+		b1:        list.New(),
+		b2:        list.New(),
+		cache:     make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes 当前占用的字节数，包括key和value
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
+func (c *Cache) Add(key string, val Value) {
+	c.AddWithExpire(key, val, time.Time{})
+}
+
+// AddWithExpire 添加一条在expireAt之后过期的记录，expireAt为零值时永不过期
+func (c *Cache) AddWithExpire(key string, val Value, expireAt time.Time) {
+	fromB2 := false
+	if element, ok := c.cache[key]; ok {
+		kv := element.Value.(*entry)
+		delta := int64(val.Len()) - int64(kv.val.Len())
+		c.nowBytes += delta
+		if kv.inT2 {
+			c.t2Bytes += delta
+		} else {
+			c.t1Bytes += delta
+		}
+		kv.val = val
+		kv.expire = expireAt
+		c.promote(element)
+	} else {
+		kv := &entry{key: key, val: val, expire: expireAt}
+		size := kv.size()
+		if g, ok := c.ghosts[key]; ok {
+			//todo 命中幽灵记录说明淘汰早了，b1命中则扩大t1，b2命中则缩小t1
+			gh := g.Value.(*ghost)
+			if gh.inB2 {
+				fromB2 = true
+				delta := size
+				//todo 大小为0的记录(空key和空值)成为幽灵记录后，b2Bytes可能为0
+				if c.b2Bytes > 0 && c.b1Bytes > c.b2Bytes {
+					delta = size * c.b1Bytes / c.b2Bytes
+				}
+				c.p = max64(c.p-delta, 0)
+			} else {
+				delta := size
+				if c.b1Bytes > 0 && c.b2Bytes > c.b1Bytes {
+					delta = size * c.b2Bytes / c.b1Bytes
+				}
+				c.p = min64(c.p+delta, c.maxBytes)
+			}
+			c.removeGhost(g)
+			kv.inT2 = true
+			c.cache[key] = c.t2.PushFront(kv)
+			c.t2Bytes += size
+		} else {
+			c.cache[key] = c.t1.PushFront(kv)
+			c.t1Bytes += size
+		}
+		c.nowBytes += size
+	}
+	for c.maxBytes != 0 && c.nowBytes > c.maxBytes {
+		c.replace(fromB2)
+	}
+	c.trimGhosts()
+}
+
+// Get 获取key对应的值，t1中的记录会被提升到t2，已经过期的记录会在这里被惰性删除
+func (c *Cache) Get(key string) (val Value, ok bool) {
+	if element, ok1 := c.cache[key]; ok1 {
+		kv := element.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(element)
+			return
+		}
+		c.promote(element)
+		return kv.val, ok1
+	}
+	return
+}
+
+// RemoveOldest 按照ARC的规则淘汰一条记录
+func (c *Cache) RemoveOldest() {
+	if len(c.cache) > 0 {
+		c.replace(false)
+	}
+}
+
+// RemoveExpired 遍历并删除所有已经过期的记录，返回删除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, element := range c.cache {
+		if element.Value.(*entry).expired(now) {
+			c.removeElement(element)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Remove 删除key对应的记录，不会触发OnEvicted
+func (c *Cache) Remove(key string) {
+	if element, ok := c.cache[key]; ok {
+		c.unlink(element)
+	}
+	if g, ok := c.ghosts[key]; ok {
+		c.removeGhost(g)
+	}
+}
+
+// promote 将记录移动到t2的最前面
+func (c *Cache) promote(element *list.Element) {
+	kv := element.Value.(*entry)
+	if kv.inT2 {
+		c.t2.MoveToFront(element)
+		return
+	}
+	c.t1.Remove(element)
+	c.t1Bytes -= kv.size()
+	kv.inT2 = true
+	c.cache[kv.key] = c.t2.PushFront(kv)
+	c.t2Bytes += kv.size()
+}
+
+// replace 从t1或t2中淘汰一条记录并放入对应的幽灵链表
+func (c *Cache) replace(fromB2 bool) {
+	var element *list.Element
+	if c.t1.Len() > 0 && (c.t1Bytes > c.p || (fromB2 && c.t1Bytes == c.p) || c.t2.Len() == 0) {
+		element = c.t1.Back()
+	} else {
+		element = c.t2.Back()
+	}
+	kv := c.unlink(element)
+	gh := &ghost{key: kv.key, size: kv.size(), inB2: kv.inT2}
+	if gh.inB2 {
+		c.ghosts[kv.key] = c.b2.PushFront(gh)
+		c.b2Bytes += gh.size
+	} else {
+		c.ghosts[kv.key] = c.b1.PushFront(gh)
+		c.b1Bytes += gh.size
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.val)
+	}
+}
+
+// trimGhosts 保证t1+b1不超过maxBytes，全部链表加起来不超过2*maxBytes
+func (c *Cache) trimGhosts() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.b1.Len() > 0 && c.t1Bytes+c.b1Bytes > c.maxBytes {
+		c.removeGhost(c.b1.Back())
+	}
+	for c.b2.Len() > 0 && c.nowBytes+c.b1Bytes+c.b2Bytes > 2*c.maxBytes {
+		c.removeGhost(c.b2.Back())
+	}
+}
+
+func (c *Cache) removeGhost(element *list.Element) {
+	gh := element.Value.(*ghost)
+	if gh.inB2 {
+		c.b2.Remove(element)
+		c.b2Bytes -= gh.size
+	} else {
+		c.b1.Remove(element)
+		c.b1Bytes -= gh.size
+	}
+	delete(c.ghosts, gh.key)
+}
+
+func (c *Cache) unlink(element *list.Element) *entry {
+	kv := element.Value.(*entry)
+	if kv.inT2 {
+		c.t2.Remove(element)
+		c.t2Bytes -= kv.size()
+	} else {
+		c.t1.Remove(element)
+		c.t1Bytes -= kv.size()
+	}
+	delete(c.cache, kv.key)
+	c.nowBytes -= kv.size()
+	return kv
+}
+
+func (c *Cache) removeElement(element *list.Element) {
+	kv := c.unlink(element)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.val)
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Hottest 先返回t2(访问过多次)再返回t1中的记录，各自从最近访问的开始，最多为n条没有过期的记录调用fn
+func (c *Cache) Hottest(n int, fn func(key string, val Value, expire time.Time)) {
+	now := time.Now()
+	for _, l := range []*list.List{c.t2, c.t1} {
+		for element := l.Front(); element != nil && n > 0; element = element.Next() {
+			kv := element.Value.(*entry)
+			if kv.expired(now) {
+				continue
+			}
+			fn(kv.key, kv.val, kv.expire)
+			n--
+		}
+	}
+}
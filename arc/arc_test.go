@@ -0,0 +1,134 @@
+package arc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	arc := NewCache(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	if v, ok := arc.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+	if arc.t1.Len() != 0 || arc.t2.Len() != 1 {
+		t.Fatalf("key1 should be promoted to t2 after a hit")
+	}
+}
+
+func TestScanResistance(t *testing.T) {
+	//todo 容量40字节，热点记录每条4字节
+	arc := NewCache(int64(40), nil)
+	hot := []string{"h0", "h1", "h2", "h3"}
+	for _, k := range hot {
+		arc.Add(k, String("vv"))
+		arc.Get(k)
+	}
+	for i := 0; i < 100; i++ {
+		arc.Add(fmt.Sprintf("s%d", i), String("v"))
+	}
+	for _, k := range hot {
+		if _, ok := arc.Get(k); !ok {
+			t.Fatalf("hot key %s should survive a scan", k)
+		}
+	}
+	if arc.nowBytes > 40 {
+		t.Fatalf("nowBytes %d exceeds maxBytes", arc.nowBytes)
+	}
+}
+
+func TestGhostHit(t *testing.T) {
+	evicted := 0
+	arc := NewCache(int64(8), func(key string, value Value) {
+		evicted++
+	})
+	arc.Add("k1", String("v1"))
+	arc.Get("k1")
+	arc.Add("k2", String("v2"))
+	arc.Add("k3", String("v3"))
+	if _, ok := arc.ghosts["k2"]; !ok || evicted != 1 {
+		t.Fatalf("k2 should be evicted into b1")
+	}
+	arc.Add("k2", String("v2"))
+	if arc.p == 0 {
+		t.Fatalf("a b1 ghost hit should grow p")
+	}
+	if element, ok := arc.cache["k2"]; !ok || !element.Value.(*entry).inT2 {
+		t.Fatalf("k2 should be re-admitted into t2")
+	}
+}
+
+func TestZeroSizeGhostHit(t *testing.T) {
+	//todo 空key和空值的记录大小为0，它所在的幽灵链表的字节数为0
+	arc := NewCache(int64(8), nil)
+	arc.Add("", String(""))
+	arc.Get("")
+	arc.Add("k1", String("v1"))
+	arc.replace(false)
+	arc.replace(false)
+	if arc.b1Bytes != 4 || arc.b2Bytes != 0 || arc.b2.Len() != 1 {
+		t.Fatalf("k1 should be in b1 and the empty key in b2, b1 %d bytes, b2 %d bytes", arc.b1Bytes, arc.b2Bytes)
+	}
+	arc.Add("", String(""))
+	if element, ok := arc.cache[""]; !ok || !element.Value.(*entry).inT2 {
+		t.Fatal("the empty key should be re-admitted into t2")
+	}
+
+	arc = NewCache(int64(8), nil)
+	arc.Add("k1", String("v1"))
+	arc.Get("k1")
+	arc.Add("", String(""))
+	arc.replace(false)
+	arc.replace(false)
+	if arc.b1Bytes != 0 || arc.b2Bytes != 4 || arc.b1.Len() != 1 {
+		t.Fatalf("the empty key should be in b1 and k1 in b2, b1 %d bytes, b2 %d bytes", arc.b1Bytes, arc.b2Bytes)
+	}
+	arc.Add("", String(""))
+	if _, ok := arc.cache[""]; !ok {
+		t.Fatal("the empty key should be re-admitted")
+	}
+}
+
+func TestRemoveAndExpire(t *testing.T) {
+	arc := NewCache(int64(0), nil)
+	arc.Add("key1", String("1234"))
+	arc.AddWithExpire("key2", String("5678"), time.Now().Add(-time.Second))
+	arc.AddWithExpire("key3", String("abcd"), time.Now().Add(-time.Second))
+	if _, ok := arc.Get("key2"); ok {
+		t.Fatalf("expired key2 should be removed lazily")
+	}
+	if removed := arc.RemoveExpired(); removed != 1 {
+		t.Fatalf("RemoveExpired should remove key3, removed %d", removed)
+	}
+	arc.Remove("key1")
+	if arc.Len() != 0 || arc.nowBytes != 0 || arc.t1Bytes != 0 || arc.t2Bytes != 0 {
+		t.Fatalf("cache should be empty, len %d bytes %d", arc.Len(), arc.nowBytes)
+	}
+}
+
+func TestHottest(t *testing.T) {
+	c := NewCache(int64(0), nil)
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+	c.Add("k3", String("v3"))
+	c.Get("k1")
+	//todo k1访问过两次，在t2中
+	keys := make([]string, 0)
+	c.Hottest(2, func(key string, val Value, expire time.Time) {
+		keys = append(keys, key)
+	})
+	if expect := []string{"k1", "k3"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect hottest keys %s, got %s", expect, keys)
+	}
+}
@@ -0,0 +1,94 @@
+package bloom
+
+import (
+	"github.com/cespare/xxhash/v2"
+	"math"
+	"math/bits"
+)
+
+// Filter Bloom filter，Test返回false时key一定没有被Add过，返回true时有一定概率误判
+// 不是并发安全的，由调用方加锁
+type Filter struct {
+	bits []uint64
+	m    uint64
+	k    int
+	//改变了位数组的Add次数，约等于不同key的数量
+	n int
+}
+
+// New 根据预计的key数量和期望的误判率计算位数组大小和哈希函数个数
+func New(expected int, fpRate float64) *Filter {
+	if expected < 1 {
+		expected = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	m := uint64(math.Ceil(-float64(expected) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(expected) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add 加入key，返回是否有位被改变，所有位都已经被设置(重复的key或者误判)时不计入Len
+func (f *Filter) Add(key string) bool {
+	h1, h2 := hash(key)
+	changed := false
+	for i := 0; i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		bit := uint64(1) << (idx % 64)
+		if f.bits[idx/64]&bit == 0 {
+			f.bits[idx/64] |= bit
+			changed = true
+		}
+	}
+	if changed {
+		f.n++
+	}
+	return changed
+}
+
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hash(key)
+	for i := 0; i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len 返回加入的不同key的数量，重复的key不会被计算多次，与已有key冲突的少量新key也不会被计算
+func (f *Filter) Len() int {
+	return f.n
+}
+
+// EstimatedFPRate 根据已经添加的key数量估算当前的误判率 (1-e^(-kn/m))^k
+func (f *Filter) EstimatedFPRate() float64 {
+	return math.Pow(1-math.Exp(-float64(f.k)*float64(f.n)/float64(f.m)), float64(f.k))
+}
+
+// FillRatio 返回位数组中被设置的位的比例，接近1时误判率迅速上升，需要用更大的ExpectedKeys重建
+func (f *Filter) FillRatio() float64 {
+	set := 0
+	for _, word := range f.bits {
+		set += bits.OnesCount64(word)
+	}
+	return float64(set) / float64(f.m)
+}
+
+// hash 用一个64位哈希模拟k个哈希函数(Kirsch-Mitzenmacher)
+func hash(key string) (uint64, uint64) {
+	sum := xxhash.Sum64String(key)
+	return sum, sum>>32 | sum<<32 | 1
+}
@@ -0,0 +1,57 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !f.Test("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d was added but not found", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 100000; i++ {
+		if f.Test("missing" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	rate := float64(falsePositives) / 100000
+	if rate > 0.02 {
+		t.Fatalf("false positive rate %.4f, want about 0.01", rate)
+	}
+	if estimated := f.EstimatedFPRate(); estimated < 0.005 || estimated > 0.02 {
+		t.Fatalf("estimated false positive rate %.4f, want about 0.01", estimated)
+	}
+	//todo 按最优的k构建时，达到预计的key数量后大约一半的位被设置
+	if fill := f.FillRatio(); fill < 0.4 || fill > 0.6 {
+		t.Fatalf("fill ratio %.3f, want about 0.5", fill)
+	}
+	if f.Len() < 9900 || f.Len() > 10000 {
+		t.Fatalf("Len = %d", f.Len())
+	}
+
+	//todo 重复的key不改变位数组，也不计入Len
+	n := f.Len()
+	for i := 0; i < 10000; i++ {
+		if f.Add("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d was added twice", i)
+		}
+	}
+	if f.Len() != n {
+		t.Fatalf("duplicate keys should not be counted, Len = %d, want %d", f.Len(), n)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	f := New(0, 0)
+	if f.Test("key") || f.EstimatedFPRate() != 0 || f.FillRatio() != 0 {
+		t.Fatal("empty filter should not contain anything")
+	}
+}
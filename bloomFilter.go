@@ -0,0 +1,196 @@
+package simpleCache
+
+import (
+	"context"
+	"github.com/thewisecirno/simple_distributed_cache/bloom"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBloomExpectedKeys = 1 << 16
+	defaultBloomFPRate       = 0.01
+)
+
+// BloomConfig Group的Bloom filter配置
+type BloomConfig struct {
+	// Keys 枚举数据源中所有存在的key，对每个key调用add，用于构建和定期重建过滤器，不能为nil
+	Keys func(ctx context.Context, add func(key string)) error
+	// ExpectedKeys 预计的key数量，为0时使用defaultBloomExpectedKeys，重建时会根据上次的数量自动扩大
+	ExpectedKeys int
+	// FPRate 期望的误判率，为0时使用defaultBloomFPRate
+	FPRate float64
+	// RebuildInterval 重建间隔，重建可以去掉已经删除的key并重新调整大小，0表示只在NewGroup时构建一次
+	RebuildInterval time.Duration
+}
+
+// WithBloomFilter 在调用本地getter之前用Bloom filter检查key是否可能存在，一定不存在的key直接返回ErrNotFound，不会访问数据库
+// 第一次构建完成之前不做检查；构建之后新增的key需要通过Group.Set写入(或者等待下一次重建)，成功加载的key也会被加入过滤器
+func WithBloomFilter(config BloomConfig) GroupOption {
+	if config.Keys == nil {
+		panic("nil bloom filter Keys")
+	}
+	if config.ExpectedKeys <= 0 {
+		config.ExpectedKeys = defaultBloomExpectedKeys
+	}
+	if config.FPRate <= 0 {
+		config.FPRate = defaultBloomFPRate
+	}
+	return func(g *Group) {
+		g.bloom = &bloomGuard{config: config}
+	}
+}
+
+// bloomGuard 并发安全地维护Group的Bloom filter
+type bloomGuard struct {
+	config BloomConfig
+
+	mu     sync.RWMutex
+	filter *bloom.Filter
+	//正在重建的过滤器，重建期间新增的key同时加入两个过滤器
+	building *bloom.Filter
+}
+
+// mayContain 返回key是否可能存在，以及过滤器是否已经构建好，没有构建好时总是返回true
+func (b *bloomGuard) mayContain(key string) (ok bool, ready bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.filter == nil {
+		return true, false
+	}
+	return b.filter.Test(key), true
+}
+
+// add 加入key，已经在过滤器中的key(例如每次成功加载的热点key)只加读锁检查，不会阻塞mayContain
+func (b *bloomGuard) add(key string) {
+	b.mu.RLock()
+	present := (b.filter == nil || b.filter.Test(key)) && (b.building == nil || b.building.Test(key))
+	b.mu.RUnlock()
+	if present {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.filter != nil {
+		b.filter.Add(key)
+	}
+	if b.building != nil {
+		b.building.Add(key)
+	}
+}
+
+// rebuild 重新枚举所有的key构建新的过滤器，失败时保留原来的过滤器
+func (b *bloomGuard) rebuild(ctx context.Context) error {
+	b.mu.Lock()
+	expected := b.config.ExpectedKeys
+	if b.filter != nil && b.filter.Len()*5/4 > expected {
+		expected = b.filter.Len() * 5 / 4
+	}
+	building := bloom.New(expected, b.config.FPRate)
+	b.building = building
+	b.mu.Unlock()
+
+	err := b.config.Keys(ctx, func(key string) {
+		b.mu.Lock()
+		building.Add(key)
+		b.mu.Unlock()
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.building = nil
+	if err != nil {
+		return err
+	}
+	b.filter = building
+	return nil
+}
+
+// start 构建过滤器，并在RebuildInterval>0时定期重建，stop关闭时取消正在进行的重建并退出
+func (b *bloomGuard) start(logger *slog.Logger, stop <-chan struct{}) {
+	go func() {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		go func() {
+			select {
+			case <-stop:
+				cancelFunc()
+			case <-ctx.Done():
+			}
+		}()
+		for {
+			if err := b.rebuild(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("bloom filter rebuild failed", "err", err)
+			}
+			if b.config.RebuildInterval <= 0 {
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(b.config.RebuildInterval):
+			}
+		}
+	}()
+}
+
+// estimatedFPRate 根据过滤器中key的数量估算的误判率
+func (b *bloomGuard) estimatedFPRate() float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.filter == nil {
+		return 0
+	}
+	return b.filter.EstimatedFPRate()
+}
+
+// fillRatio 过滤器中被设置的位的比例，没有构建好时为0
+func (b *bloomGuard) fillRatio() float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.filter == nil {
+		return 0
+	}
+	return b.filter.FillRatio()
+}
+
+// bloomRejects 在调用getter之前检查Bloom filter，rejected为true时key一定不存在，checked表示过滤器已经构建好并做了检查
+// 只在加载数据的结点上检查：其他结点的过滤器不知道owner上Set或加载的key，不能用来拦截转发给owner的请求
+func (g *Group) bloomRejects(key string) (rejected bool, checked bool) {
+	if g.bloom == nil {
+		return false, false
+	}
+	ok, ready := g.bloom.mayContain(key)
+	if !ok {
+		g.Stats.BloomRejects.Add(1)
+	}
+	return !ok, ready
+}
+
+// bloomFalsePositive 通过了过滤器但getter返回ErrNotFound，计为一次误判
+func (g *Group) bloomFalsePositive(checked bool) {
+	if checked {
+		g.Stats.BloomFalsePositives.Add(1)
+	}
+}
+
+// bloomLoaded 成功获取的key(包括从peer获取的)加入过滤器
+func (g *Group) bloomLoaded(key string, err error) {
+	if g.bloom != nil && err == nil {
+		g.bloom.add(key)
+	}
+}
+
+// BloomFPRate 返回Bloom filter估算的误判率，以及实际观察到的误判率：
+// 通过了过滤器但Getter返回ErrNotFound的次数 / 所有不存在的key的检查次数，没有开启Bloom filter时都为0
+func (g *Group) BloomFPRate() (estimated float64, observed float64) {
+	if g.bloom == nil {
+		return 0, 0
+	}
+	falsePositives := g.Stats.BloomFalsePositives.Load()
+	if absent := falsePositives + g.Stats.BloomRejects.Load(); absent > 0 {
+		observed = float64(falsePositives) / float64(absent)
+	}
+	return g.bloom.estimatedFPRate(), observed
+}
@@ -0,0 +1,164 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitBloom 等待过滤器第一次构建完成
+func waitBloom(t *testing.T, g *Group) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if _, ready := g.bloom.mayContain(""); ready {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("bloom filter was not built")
+}
+
+func TestBloomFilter(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		keys  = map[string]string{"Tom": "630", "Jack": "589"}
+		loads atomic.Int64
+	)
+	getter := GetterHandler(func(key string) ([]byte, error) {
+		loads.Add(1)
+		mu.Lock()
+		defer mu.Unlock()
+		if v, ok := keys[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	})
+	enumerate := func(ctx context.Context, add func(key string)) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for key := range keys {
+			add(key)
+		}
+		return nil
+	}
+	gee := NewGroup("bloom-scores", 2<<10, getter, WithNegativeTTL(0),
+		WithBloomFilter(BloomConfig{Keys: enumerate, ExpectedKeys: 100, RebuildInterval: 50 * time.Millisecond}))
+	waitBloom(t, gee)
+
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("Tom should be loaded, got %v %v", view, err)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := gee.Get(fmt.Sprintf("missing%d", i)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("missing%d should be not found, got %v", i, err)
+		}
+	}
+	//todo 误判的key会访问数据库，其余的被过滤器拦截
+	rejects, falsePositives := gee.Stats.BloomRejects.Load(), gee.Stats.BloomFalsePositives.Load()
+	if rejects+falsePositives != 1000 || loads.Load() != 1+falsePositives {
+		t.Fatalf("rejects %d, false positives %d, loads %d", rejects, falsePositives, loads.Load())
+	}
+	estimated, observed := gee.BloomFPRate()
+	if estimated <= 0 || estimated > 0.01 || observed > 0.05 {
+		t.Fatalf("unexpected false positive rates, estimated %f, observed %f", estimated, observed)
+	}
+
+	//todo Set写入的新key立即通过过滤器
+	mu.Lock()
+	keys["Sam"] = "567"
+	mu.Unlock()
+	if err := gee.Set(context.Background(), "Sam", []byte("567")); err != nil {
+		t.Fatal(err)
+	}
+	gee.Invalidate("Sam")
+	if view, err := gee.Get("Sam"); err != nil || view.String() != "567" {
+		t.Fatalf("Sam should pass the filter after Set, got %v %v", view, err)
+	}
+
+	//todo 直接写入数据源的key在下一次重建后通过过滤器
+	mu.Lock()
+	keys["Lily"] = "600"
+	mu.Unlock()
+	for i := 0; ; i++ {
+		if ok, _ := gee.bloom.mayContain("Lily"); ok {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Lily should be added by a rebuild")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if view, err := gee.Get("Lily"); err != nil || view.String() != "600" {
+		t.Fatalf("Lily should be loaded, got %v %v", view, err)
+	}
+}
+
+func TestBloomFilterNotReady(t *testing.T) {
+	started, build := make(chan struct{}), make(chan struct{})
+	enumerate := func(ctx context.Context, add func(key string)) error {
+		close(started)
+		<-build
+		return nil
+	}
+	gee := NewGroup("bloom-not-ready-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, ErrNotFound
+	}), WithBloomFilter(BloomConfig{Keys: enumerate}))
+	<-started
+
+	//todo 构建完成之前不拦截，也不计入误判
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("Tom should be loaded before the filter is built, got %v %v", view, err)
+	}
+	if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown should be not found, got %v", err)
+	}
+	if gee.Stats.BloomRejects.Load() != 0 || gee.Stats.BloomFalsePositives.Load() != 0 {
+		t.Fatal("filter should not be consulted before it is built")
+	}
+
+	close(build)
+	waitBloom(t, gee)
+	//todo 构建期间成功加载的Tom也在新的过滤器中
+	if ok, _ := gee.bloom.mayContain("Tom"); !ok {
+		t.Fatal("Tom was loaded during the build and should be in the filter")
+	}
+}
+
+func TestBloomFilterClose(t *testing.T) {
+	var builds atomic.Int64
+	gee := NewGroup("bloom-close-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithBloomFilter(BloomConfig{Keys: func(ctx context.Context, add func(key string)) error {
+		builds.Add(1)
+		add("Tom")
+		return nil
+	}, RebuildInterval: 10 * time.Millisecond}))
+	waitBloom(t, gee)
+
+	//todo 已经在过滤器中的key重复加载不会增加数量
+	for i := 0; i < 10; i++ {
+		gee.bloomLoaded("Tom", nil)
+	}
+	gee.bloom.mu.RLock()
+	n := gee.bloom.filter.Len()
+	gee.bloom.mu.RUnlock()
+	if n != 1 {
+		t.Fatalf("Tom should be counted once, got %d", n)
+	}
+
+	//todo Close之后不再重建
+	gee.Close()
+	time.Sleep(30 * time.Millisecond)
+	stopped := builds.Load()
+	time.Sleep(50 * time.Millisecond)
+	if builds.Load() != stopped {
+		t.Fatalf("bloom filter should not be rebuilt after Close, builds %d -> %d", stopped, builds.Load())
+	}
+}
@@ -1,9 +1,20 @@
 package simpleCache
 
-import "bytes"
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+)
 
 type ByteView struct {
 	byteView []byte
+	//过期时间，零值表示永不过期
+	expire time.Time
+	//超过refreshAt(UnixNano)后在后台重新加载(refresh-ahead)，零值表示不提前刷新
+	//记录被多个调用者共享，刷新时用CAS推后它，刷新失败后一段时间内不会再次刷新
+	refreshAt atomic.Int64
+	//过期之后继续保留到evictAt，用于stale-while-revalidate和serve-stale-on-error，零值表示过期即删除
+	evictAt time.Time
 }
 
 func (b *ByteView) Len() int {
@@ -18,6 +29,32 @@ func (b *ByteView) ByteSlice() []byte {
 	return cloneByte(b.byteView)
 }
 
+// Expire 返回过期时间，零值表示永不过期
+func (b *ByteView) Expire() time.Time {
+	return b.expire
+}
+
+// expired 是否已经过期，过期的记录只有在evictAt之前才会留在缓存中
+func (b *ByteView) expired(now time.Time) bool {
+	return !b.expire.IsZero() && now.After(b.expire)
+}
+
+// evictTime 记录从缓存中删除的时间
+func (b *ByteView) evictTime() time.Time {
+	if b.evictAt.IsZero() {
+		return b.expire
+	}
+	return b.evictAt
+}
+
+// expireUnixNano 转换成pb.Response中的expire字段
+func (b *ByteView) expireUnixNano() int64 {
+	if b.expire.IsZero() {
+		return 0
+	}
+	return b.expire.UnixNano()
+}
+
 func cloneByte(b []byte) []byte {
 	clone := bytes.Clone(b)
 	return clone
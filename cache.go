@@ -2,13 +2,19 @@ package simpleCache
 
 import (
 	"github.com/thewisecirno/simple_distributed_cache/lru"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type cache struct {
 	mu         sync.Mutex
-	cache      *lru.Cache
+	cache      Policy
+	policy     PolicyType
 	cacheBytes int64
+	//因为容量不足或者过期被删除的记录数
+	evictions atomic.Int64
 }
 
 func (c *cache) get(key string) (byteView *ByteView, ok1 bool) {
@@ -27,7 +33,189 @@ func (c *cache) add(key string, val *ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.cache == nil {
-		c.cache = lru.NewCache(c.cacheBytes, nil)
+		c.cache = newPolicy(c.policy, c.cacheBytes, func(string, lru.Value) {
+			c.evictions.Add(1)
+		})
 	}
-	c.cache.Add(key, val)
+	c.cache.AddWithExpire(key, val, val.evictTime())
+}
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return
+	}
+	c.cache.Remove(key)
+}
+
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.Len()
+}
+
+func (c *cache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.Bytes()
+}
+
+// hotEntry hottest返回的一条记录，rank为它在所在分片中的热度排名，0为最热
+type hotEntry struct {
+	key  string
+	view *ByteView
+	rank int
+}
+
+// hottest 按热度从高到低返回淘汰策略认为最热的n条没有过期的记录
+func (c *cache) hottest(n int) []hotEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var hot []hotEntry
+	if c.cache == nil {
+		return hot
+	}
+	c.cache.Hottest(n, func(key string, val lru.Value, expire time.Time) {
+		hot = append(hot, hotEntry{key: key, view: val.(*ByteView), rank: len(hot)})
+	})
+	return hot
+}
+
+func (c *cache) removeExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0
+	}
+	return c.cache.RemoveExpired()
+}
+
+const (
+	// defaultShards 默认的分片数
+	defaultShards = 16
+	// minShardBytes 默认分片时每个分片至少有这么多容量，容量更小的缓存减少分片数，避免较大的值放不进一个分片
+	minShardBytes = 1 << 20
+)
+
+// shardedCache 按key的哈希把记录分散到多个cache中，每个分片有自己的锁和cacheBytes/n的容量
+// 淘汰策略在get时也需要修改内部链表，只能加互斥锁，分片可以让多核下的请求落在不同的锁上
+type shardedCache struct {
+	shards []*cache
+}
+
+func newShardedCache(cacheBytes int64, shards int, policy PolicyType) *shardedCache {
+	//todo 每个分片只有cacheBytes/shards的容量，比它大的值加入后立即被淘汰，所以默认分片时每个分片至少有minShardBytes
+	if shards <= 0 {
+		shards = defaultShards
+		if cacheBytes > 0 && cacheBytes/minShardBytes < defaultShards {
+			shards = int(max(cacheBytes/minShardBytes, 1))
+		}
+	}
+	//todo 每个分片的容量为0会变成不限制容量，分片数不能超过cacheBytes
+	if cacheBytes > 0 && int64(shards) > cacheBytes {
+		shards = int(cacheBytes)
+	}
+	s := &shardedCache{shards: make([]*cache, shards)}
+	//todo 除不尽的部分分给前面的分片，所有分片的容量加起来正好是cacheBytes
+	per, rest := cacheBytes/int64(shards), cacheBytes%int64(shards)
+	for i := range s.shards {
+		shardBytes := per
+		if int64(i) < rest {
+			shardBytes++
+		}
+		s.shards[i] = &cache{policy: policy, cacheBytes: shardBytes}
+	}
+	return s
+}
+
+// shard 使用FNV-1a选择分片，这里手写是为了避免hash.Hash32的内存分配
+func (s *shardedCache) shard(key string) *cache {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return s.shards[hash%uint32(len(s.shards))]
+}
+
+func (s *shardedCache) get(key string) (*ByteView, bool) {
+	return s.shard(key).get(key)
+}
+
+func (s *shardedCache) add(key string, val *ByteView) {
+	s.shard(key).add(key, val)
+}
+
+func (s *shardedCache) remove(key string) {
+	s.shard(key).remove(key)
+}
+
+func (s *shardedCache) len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.len()
+	}
+	return n
+}
+
+func (s *shardedCache) bytes() int64 {
+	var n int64
+	for _, shard := range s.shards {
+		n += shard.bytes()
+	}
+	return n
+}
+
+func (s *shardedCache) evictions() int64 {
+	var n int64
+	for _, shard := range s.shards {
+		n += shard.evictions.Load()
+	}
+	return n
+}
+
+// hottest 返回最多n条最热的记录，分片之间的热度无法直接比较，先取每个分片最热的n条，按在分片中的排名合并后截取前n条
+func (s *shardedCache) hottest(n int) []hotEntry {
+	var candidates []hotEntry
+	for _, shard := range s.shards {
+		candidates = append(candidates, shard.hottest(n)...)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].rank < candidates[j].rank
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+func (s *shardedCache) removeExpired() int {
+	removed := 0
+	for _, shard := range s.shards {
+		removed += shard.removeExpired()
+	}
+	return removed
+}
+
+// startJanitor 后台每隔interval清理一次过期的记录，避免过期但不再被访问的记录一直占用空间，stop关闭时退出
+func (s *shardedCache) startJanitor(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.removeExpired()
+			case <-stop:
+				return
+			}
+		}
+	}()
 }
@@ -0,0 +1,117 @@
+package simpleCache
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestShardedCacheHottest(t *testing.T) {
+	c := newShardedCache(0, 16, LRU)
+	for i := 0; i < 100; i++ {
+		c.add(strconv.Itoa(i), &ByteView{byteView: []byte(strconv.Itoa(i))})
+	}
+	//todo 分片数多于n时也只返回n条
+	for _, n := range []int{1, 5, 20} {
+		if got := len(c.hottest(n)); got != n {
+			t.Fatalf("hottest(%d) returned %d entries", n, got)
+		}
+	}
+	if got := len(c.hottest(1000)); got != 100 {
+		t.Fatalf("hottest should return at most all entries, got %d", got)
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	if n := len(newShardedCache(2<<10, 0, LRU).shards); n != 1 {
+		t.Fatalf("small cache should not be sharded by default, got %d shards", n)
+	}
+	//todo 默认分片时每个分片至少minShardBytes
+	if n := len(newShardedCache(4*minShardBytes+100, 0, LRU).shards); n != 4 {
+		t.Fatalf("cache of 4 shards' worth should use 4 shards, got %d", n)
+	}
+	if n := len(newShardedCache(64<<20, 0, LRU).shards); n != defaultShards {
+		t.Fatalf("large cache should use %d shards, got %d", defaultShards, n)
+	}
+	if n := len(newShardedCache(0, 0, LRU).shards); n != defaultShards {
+		t.Fatalf("unlimited cache should use %d shards, got %d", defaultShards, n)
+	}
+	if n := len(newShardedCache(100, 4, LRU).shards); n != 4 {
+		t.Fatalf("WithShards should be respected, got %d shards", n)
+	}
+	//todo 分片数超过cacheBytes时每个分片至少1字节，不能变成不限制容量
+	tiny := newShardedCache(4, 16, LRU)
+	if len(tiny.shards) != 4 || tiny.shards[0].cacheBytes != 1 {
+		t.Fatalf("shards should be capped at cacheBytes, got %d shards of %d bytes", len(tiny.shards), tiny.shards[0].cacheBytes)
+	}
+	//todo 除不尽的部分分给前面的分片
+	uneven := newShardedCache(103, 4, LRU)
+	var total int64
+	for i, shard := range uneven.shards {
+		total += shard.cacheBytes
+		want := int64(25)
+		if i < 3 {
+			want = 26
+		}
+		if shard.cacheBytes != want {
+			t.Fatalf("shard %d has %d bytes, want %d", i, shard.cacheBytes, want)
+		}
+	}
+	if total != 103 {
+		t.Fatalf("shards should hold 103 bytes together, got %d", total)
+	}
+
+	c := newShardedCache(0, 8, LRU)
+	for i := 0; i < 1000; i++ {
+		c.add(strconv.Itoa(i), &ByteView{byteView: []byte(strconv.Itoa(i))})
+	}
+	if c.len() != 1000 {
+		t.Fatalf("expect 1000 entries, got %d", c.len())
+	}
+	for _, shard := range c.shards {
+		if shard.len() == 0 {
+			t.Fatal("keys should be spread over all shards")
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if view, ok := c.get(strconv.Itoa(i)); !ok || view.String() != strconv.Itoa(i) {
+			t.Fatalf("cache hit %d failed", i)
+		}
+	}
+	c.remove("1")
+	if _, ok := c.get("1"); ok || c.len() != 999 {
+		t.Fatal("remove 1 failed")
+	}
+}
+
+func BenchmarkCacheParallel(b *testing.B) {
+	const keys = 1 << 16
+	value := &ByteView{byteView: make([]byte, 64)}
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := newShardedCache(0, shards, LRU)
+			for _, name := range names {
+				c.add(name, value)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					name := names[r.Intn(keys)]
+					//todo 读写比例9:1
+					if r.Intn(10) == 0 {
+						c.add(name, value)
+					} else {
+						c.get(name)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,368 @@
+// Package cachetest 在一个进程中启动多个结点组成集群，结点之间通过httptest服务器和discovery.Memory互相访问，
+// 可以杀死结点、制造网络分区，并检查每个key由哪个结点加载，不需要启动多个进程和etcd
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	simpleCache "github.com/thewisecirno/simple_distributed_cache"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ErrPartitioned 被分区的结点访问其他结点时返回
+var ErrPartitioned = errors.New("cachetest: node is partitioned")
+
+// convergeTimeout 等待所有结点的哈希环一致的最长时间
+const convergeTimeout = 5 * time.Second
+
+// Cluster 进程内的缓存集群
+type Cluster struct {
+	t testing.TB
+	// Discovery 所有结点共用的注册中心
+	Discovery *discovery.Memory
+	nodes     []*Node
+	poolOpts  []simpleCache.HTTPPoolOption
+}
+
+type Option func(*Cluster)
+
+// WithPoolOptions 创建每个结点的HTTPPool时使用的选项
+func WithPoolOptions(opts ...simpleCache.HTTPPoolOption) Option {
+	return func(c *Cluster) {
+		c.poolOpts = append(c.poolOpts, opts...)
+	}
+}
+
+// Node 集群中的一个结点
+type Node struct {
+	*simpleCache.Node
+	Index int
+	// Addr httptest服务器的地址，也是结点在哈希环上的名字
+	Addr string
+	Pool *simpleCache.HTTPPool
+
+	server      *httptest.Server
+	client      *discovery.MemoryClient
+	killed      atomic.Bool
+	partitioned atomic.Bool
+
+	mu sync.Mutex
+	//group -> key -> getter被调用的次数
+	loads map[string]map[string]int
+}
+
+// New 启动n个结点并等待它们互相发现，测试结束时自动关闭
+func New(t testing.TB, n int, opts ...Option) *Cluster {
+	if n <= 0 {
+		panic("cachetest: cluster needs at least one node")
+	}
+	c := &Cluster{t: t, Discovery: discovery.NewMemory()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for i := 0; i < n; i++ {
+		node := &Node{
+			Node:   simpleCache.NewNode(),
+			Index:  i,
+			server: httptest.NewUnstartedServer(nil),
+			client: c.Discovery.Client(),
+			loads:  make(map[string]map[string]int),
+		}
+		node.Addr = node.server.Listener.Addr().String()
+		node.Pool = node.Node.NewHTTPPool(node.Addr, "", node.client, c.poolOpts...)
+		//todo 替换掉HTTPPool，分区时从这个结点发出的请求也会失败
+		node.Node.RegisterPeers(&picker{pool: node.Pool, from: node})
+		node.server.Config.Handler = node
+		node.server.Start()
+		c.nodes = append(c.nodes, node)
+	}
+	t.Cleanup(c.Close)
+	c.WaitConverged()
+	return c
+}
+
+// Nodes 返回所有结点，包括已经被杀死的
+func (c *Cluster) Nodes() []*Node {
+	return c.nodes
+}
+
+func (c *Cluster) Node(i int) *Node {
+	return c.nodes[i]
+}
+
+// Loader 结点node加载key，为nil时返回key本身
+type Loader func(ctx context.Context, node *Node, key string) ([]byte, error)
+
+// NewGroup 在每个结点上创建同名的Group，getter的调用会被记录下来，返回的Group与结点一一对应
+func (c *Cluster) NewGroup(name string, cacheBytes int64, load Loader, opts ...simpleCache.GroupOption) []*simpleCache.Group {
+	groups := make([]*simpleCache.Group, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		node := node
+		groups = append(groups, node.NewGroup(name, cacheBytes, simpleCache.ContextGetterHandler(func(ctx context.Context, key string) ([]byte, error) {
+			node.record(name, key)
+			if load == nil {
+				return []byte(key), nil
+			}
+			return load(ctx, node, key)
+		}), opts...))
+	}
+	return groups
+}
+
+// Kill 模拟结点崩溃并且租约已经过期：关闭服务器，从注册中心删除，等待其他结点把它移出哈希环
+func (c *Cluster) Kill(i int) {
+	node := c.nodes[i]
+	if node.killed.Swap(true) {
+		return
+	}
+	_ = node.client.Close()
+	node.server.Close()
+	c.WaitConverged()
+}
+
+// Partition 结点i与其他结点之间的网络断开，但仍然在注册中心和其他结点的哈希环中，
+// 其他结点访问它时连接被关闭，它访问其他结点时返回ErrPartitioned
+func (c *Cluster) Partition(i int) {
+	c.nodes[i].partitioned.Store(true)
+}
+
+// Heal 恢复Partition断开的网络
+func (c *Cluster) Heal(i int) {
+	c.nodes[i].partitioned.Store(false)
+}
+
+// WaitConverged 等待每个存活结点的哈希环包含注册中心中的所有结点
+func (c *Cluster) WaitConverged() {
+	c.t.Helper()
+	deadline := time.Now().Add(convergeTimeout)
+	for !c.converged() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("cachetest: peers did not converge on %v", c.Discovery.Peers())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (c *Cluster) converged() bool {
+	registered := len(c.Discovery.Peers())
+	for _, node := range c.nodes {
+		if !node.killed.Load() && len(node.Pool.GetAll()) != registered-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Owner 返回哈希环上负责key的结点，以第一个存活结点的视角为准
+func (c *Cluster) Owner(key string) int {
+	c.t.Helper()
+	for _, node := range c.nodes {
+		if node.killed.Load() {
+			continue
+		}
+		peer, ok := node.Pool.PickOwner(key)
+		if !ok {
+			return node.Index
+		}
+		for _, owner := range c.nodes {
+			if strings.HasPrefix(fmt.Sprint(peer), owner.Addr+"/") {
+				return owner.Index
+			}
+		}
+		c.t.Fatalf("cachetest: unknown peer %v", peer)
+	}
+	c.t.Fatal("cachetest: all nodes are killed")
+	return -1
+}
+
+// LoadedBy 返回调用过getter加载group中key的结点，按序号排序
+func (c *Cluster) LoadedBy(group, key string) []int {
+	var loaded []int
+	for _, node := range c.nodes {
+		if node.Loads(group, key) > 0 {
+			loaded = append(loaded, node.Index)
+		}
+	}
+	sort.Ints(loaded)
+	return loaded
+}
+
+// AssertLoadedBy 检查key只由want中的结点加载，并且每个结点只加载了一次
+func (c *Cluster) AssertLoadedBy(group, key string, want ...int) {
+	c.t.Helper()
+	sort.Ints(want)
+	got := c.LoadedBy(group, key)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		c.t.Errorf("cachetest: %s/%s should be loaded by %v, got %v", group, key, want, got)
+		return
+	}
+	for _, i := range want {
+		if loads := c.nodes[i].Loads(group, key); loads != 1 {
+			c.t.Errorf("cachetest: node %d loaded %s/%s %d times", i, group, key, loads)
+		}
+	}
+}
+
+// ResetLoads 清空所有结点的加载记录
+func (c *Cluster) ResetLoads() {
+	for _, node := range c.nodes {
+		node.mu.Lock()
+		node.loads = make(map[string]map[string]int)
+		node.mu.Unlock()
+	}
+}
+
+// Close 关闭所有结点
+func (c *Cluster) Close() {
+	for _, node := range c.nodes {
+		if !node.killed.Swap(true) {
+			_ = node.client.Close()
+			node.server.Close()
+		}
+		_ = node.Node.Close()
+	}
+}
+
+// Loads 返回结点调用getter加载group中key的次数
+func (n *Node) Loads(group, key string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.loads[group][key]
+}
+
+func (n *Node) record(group, key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.loads[group] == nil {
+		n.loads[group] = make(map[string]int)
+	}
+	n.loads[group][key]++
+}
+
+// ServeHTTP 被分区时直接关闭连接，模拟网络断开
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if n.partitioned.Load() {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				_ = conn.Close()
+				return
+			}
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	n.Pool.ServeHTTP(w, r)
+}
+
+// picker 包装HTTPPool，from被分区时返回的peer都无法访问
+type picker struct {
+	pool *simpleCache.HTTPPool
+	from *Node
+
+	//同一个peer总是返回同一个包装，Group用==判断两个peer是否相同(例如Set时跳过owner)
+	mu      sync.Mutex
+	wrapped map[simpleCache.PeerGetter]*peerGetter
+}
+
+func (p *picker) PickPeer(key string) (simpleCache.PeerGetter, bool) {
+	peer, ok := p.pool.PickPeer(key)
+	if !ok {
+		return nil, false
+	}
+	return p.wrapOne(peer), true
+}
+
+func (p *picker) PickOwner(key string) (simpleCache.PeerGetter, bool) {
+	peer, ok := p.pool.PickOwner(key)
+	if !ok {
+		return nil, false
+	}
+	return p.wrapOne(peer), true
+}
+
+func (p *picker) GetAll() []simpleCache.PeerGetter {
+	return p.wrap(p.pool.GetAll())
+}
+
+func (p *picker) PickReplicas(key string, n int) []simpleCache.PeerGetter {
+	return p.wrap(p.pool.PickReplicas(key, n))
+}
+
+func (p *picker) wrap(peers []simpleCache.PeerGetter) []simpleCache.PeerGetter {
+	wrapped := make([]simpleCache.PeerGetter, 0, len(peers))
+	for _, peer := range peers {
+		wrapped = append(wrapped, p.wrapOne(peer))
+	}
+	return wrapped
+}
+
+func (p *picker) wrapOne(peer simpleCache.PeerGetter) *peerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.wrapped == nil {
+		p.wrapped = make(map[simpleCache.PeerGetter]*peerGetter)
+	}
+	w, ok := p.wrapped[peer]
+	if !ok {
+		w = &peerGetter{PeerGetter: peer, from: p.from}
+		p.wrapped[peer] = w
+	}
+	return w
+}
+
+type peerGetter struct {
+	simpleCache.PeerGetter
+	from *Node
+}
+
+func (p *peerGetter) String() string {
+	return fmt.Sprint(p.PeerGetter)
+}
+
+func (p *peerGetter) Get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	if p.from.partitioned.Load() {
+		return ErrPartitioned
+	}
+	return p.PeerGetter.Get(ctx, req, res)
+}
+
+func (p *peerGetter) Set(ctx context.Context, req *pb.SetRequest) error {
+	if p.from.partitioned.Load() {
+		return ErrPartitioned
+	}
+	return p.PeerGetter.Set(ctx, req)
+}
+
+func (p *peerGetter) Remove(ctx context.Context, req *pb.Request) error {
+	if p.from.partitioned.Load() {
+		return ErrPartitioned
+	}
+	return p.PeerGetter.Remove(ctx, req)
+}
+
+func (p *peerGetter) GetMulti(ctx context.Context, req *pb.GetMultiRequest, res *pb.GetMultiResponse) error {
+	if p.from.partitioned.Load() {
+		return ErrPartitioned
+	}
+	batch, ok := p.PeerGetter.(simpleCache.BatchPeerGetter)
+	if !ok {
+		return errors.New("cachetest: peer does not support GetMulti")
+	}
+	return batch.GetMulti(ctx, req, res)
+}
+
+var (
+	_ simpleCache.PeerPicker      = (*picker)(nil)
+	_ simpleCache.ReplicaPicker   = (*picker)(nil)
+	_ simpleCache.BatchPeerGetter = (*peerGetter)(nil)
+)
@@ -0,0 +1,116 @@
+package cachetest
+
+import (
+	"context"
+	"fmt"
+	simpleCache "github.com/thewisecirno/simple_distributed_cache"
+	"testing"
+	"time"
+)
+
+// keyOwnedBy 找到一个由结点i负责的key
+func keyOwnedBy(t *testing.T, c *Cluster, i int) string {
+	t.Helper()
+	for j := 0; j < 100000; j++ {
+		key := fmt.Sprintf("key%d", j)
+		if c.Owner(key) == i {
+			return key
+		}
+	}
+	t.Fatalf("no key is owned by node %d", i)
+	return ""
+}
+
+func TestCluster(t *testing.T) {
+	c := New(t, 3)
+	groups := c.NewGroup("scores", 2<<10, nil)
+	owners := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		view, err := groups[i%3].Get(key)
+		if err != nil || view.String() != key {
+			t.Fatalf("get %s failed: %v %v", key, view, err)
+		}
+		//todo 无论从哪个结点访问，都只由owner加载一次
+		owner := c.Owner(key)
+		owners[owner] = true
+		if _, err = groups[(i+1)%3].Get(key); err != nil {
+			t.Fatal(err)
+		}
+		c.AssertLoadedBy("scores", key, owner)
+	}
+	//todo 每个结点只有DefaultReplicas个虚拟结点，负责的key可能很少
+	if len(owners) < 2 {
+		t.Fatalf("keys should be spread over all nodes, got %v", owners)
+	}
+}
+
+func TestClusterKill(t *testing.T) {
+	c := New(t, 3)
+	groups := c.NewGroup("scores", 2<<10, nil)
+	key := keyOwnedBy(t, c, 2)
+	c.Kill(2)
+	if owner := c.Owner(key); owner == 2 {
+		t.Fatal("a killed node should leave the ring")
+	}
+	if _, err := groups[0].Get(key); err != nil {
+		t.Fatal(err)
+	}
+	c.AssertLoadedBy("scores", key, c.Owner(key))
+}
+
+func TestClusterPartition(t *testing.T) {
+	c := New(t, 3)
+	groups := c.NewGroup("scores", 2<<10, func(ctx context.Context, node *Node, key string) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s@%d", key, node.Index)), nil
+	})
+
+	//todo 访问被分区的owner失败，在本地加载
+	c.Partition(1)
+	key := keyOwnedBy(t, c, 1)
+	view, err := groups[0].Get(key)
+	if err != nil || view.String() != key+"@0" {
+		t.Fatalf("node 0 should load %s locally, got %v %v", key, view, err)
+	}
+	c.AssertLoadedBy("scores", key, 0)
+
+	//todo 被分区的结点也无法访问其他结点
+	other := keyOwnedBy(t, c, 2)
+	if view, err = groups[1].Get(other); err != nil || view.String() != other+"@1" {
+		t.Fatalf("node 1 should load %s locally, got %v %v", other, view, err)
+	}
+
+	c.Heal(1)
+	c.ResetLoads()
+	//todo 恢复后其他结点重新从owner获取
+	if view, err = groups[2].Get(key); err != nil || view.String() != key+"@1" {
+		t.Fatalf("node 2 should get %s from node 1, got %v %v", key, view, err)
+	}
+	c.AssertLoadedBy("scores", key, 1)
+}
+
+func TestClusterBloomFilter(t *testing.T) {
+	c := New(t, 3)
+	groups := c.NewGroup("bloom-scores", 2<<10, func(ctx context.Context, node *Node, key string) ([]byte, error) {
+		return nil, simpleCache.ErrNotFound
+	}, simpleCache.WithBloomFilter(simpleCache.BloomConfig{Keys: func(ctx context.Context, add func(key string)) error {
+		return nil
+	}}))
+	key := keyOwnedBy(t, c, 1)
+	//todo 等待所有结点的过滤器构建完成
+	time.Sleep(50 * time.Millisecond)
+
+	//todo 只有owner知道Set写入的key，其他结点的过滤器不能拦截转发给owner的请求
+	if err := groups[0].Set(context.Background(), key, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	for i, group := range groups {
+		if view, err := group.Get(key); err != nil || view.String() != "new" {
+			t.Fatalf("node %d should get %s from the owner, got %v %v", i, key, view, err)
+		}
+	}
+	results := groups[2].GetMulti(context.Background(), []string{key})
+	if r := results[key]; r.Err != nil || r.View.String() != "new" {
+		t.Fatalf("GetMulti on node 2 should get %s from the owner, got %v %v", key, r.View, r.Err)
+	}
+}
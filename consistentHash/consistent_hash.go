@@ -1,7 +1,6 @@
 package consistentHash
 
 import (
-	"hash/crc32"
 	"sort"
 	"strconv"
 )
@@ -9,11 +8,15 @@ import (
 const DefaultReplicas = 3
 
 type Hash func([]byte) uint32
+
+// ConsistentHash 哈希环，每个结点对应replicas*weight个虚拟结点，key由顺时针方向的第一个虚拟结点负责
 type ConsistentHash struct {
 	hash     Hash
 	replicas int
 	keys     []int
 	hashMap  map[int]string
+	//环上的真实结点 -> 权重
+	nodes map[string]int
 }
 
 func NewConsistentHash(replicas int, fn Hash) *ConsistentHash {
@@ -22,37 +25,117 @@ func NewConsistentHash(replicas int, fn Hash) *ConsistentHash {
 		hash:     fn,
 		keys:     make([]int, 0),
 		hashMap:  make(map[int]string),
+		nodes:    make(map[string]int),
 	}
 	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE
+		m.hash = CRC32
 	}
 	return m
 }
 
 func (h *ConsistentHash) Add(keys ...string) {
 	for _, v := range keys {
-		for i := 0; i < h.replicas; i++ {
-			hashNumber := h.hash([]byte(strconv.Itoa(i) + v))
-			h.keys = append(h.keys, int(hashNumber))
-			h.hashMap[int(hashNumber)] = v
-		}
+		h.AddWeighted(v, 1)
+	}
+}
+
+// AddWeighted 添加一个结点，虚拟结点数为replicas*weight，weight小于1时按1处理，结点已经存在时修改权重
+// 权重为1时与Add的虚拟结点相同，权重增加时原有的虚拟结点不变，只有一部分key会移动到这个结点
+func (h *ConsistentHash) AddWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if _, ok := h.nodes[key]; ok {
+		h.Del(key)
+	}
+	h.nodes[key] = weight
+	for i := 0; i < h.replicas*weight; i++ {
+		hashNumber := h.hash([]byte(strconv.Itoa(i) + key))
+		h.keys = append(h.keys, int(hashNumber))
+		h.hashMap[int(hashNumber)] = key
 	}
 	sort.Ints(h.keys)
 }
 
+// SetWeight 修改结点的权重
+func (h *ConsistentHash) SetWeight(key string, weight int) {
+	h.Del(key)
+	h.AddWeighted(key, weight)
+}
+
+// Weight 返回结点的权重，结点不存在时返回0
+func (h *ConsistentHash) Weight(key string) int {
+	return h.nodes[key]
+}
+
 func (h *ConsistentHash) Get(key string) string {
 	if len(h.keys) == 0 {
 		return ""
 	}
+	return h.hashMap[h.keys[h.search(key)]]
+}
+
+// GetN 从key的位置顺时针收集n个不同的结点，第一个结点被删除后它的key正好由第二个结点负责
+func (h *ConsistentHash) GetN(key string, n int) []string {
+	if len(h.keys) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(h.nodes) {
+		n = len(h.nodes)
+	}
+	nodes := make([]string, 0, n)
+	start := h.search(key)
+	for i := 0; i < len(h.keys) && len(nodes) < n; i++ {
+		if node := h.hashMap[h.keys[(start+i)%len(h.keys)]]; !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func contains(nodes []string, node string) bool {
+	for _, v := range nodes {
+		if v == node {
+			return true
+		}
+	}
+	return false
+}
+
+// search 返回key顺时针方向第一个虚拟结点的下标
+func (h *ConsistentHash) search(key string) int {
 	hashNumber := int(h.hash([]byte(key)))
 	n := sort.Search(len(h.keys), func(i int) bool {
 		return h.keys[i] >= hashNumber
 	})
-	return h.hashMap[h.keys[n%len(h.keys)]]
+	return n % len(h.keys)
+}
+
+// GetBounded 带负载上限的Get(consistent hashing with bounded loads)
+// 从key的位置顺时针跳过已经达到上限的结点，因此热点key不会把同一个结点打垮，而负载不高时与Get的结果相同。
+// factor应该大于1，load返回结点当前的负载
+func (h *ConsistentHash) GetBounded(key string, factor float64, load func(node string) int64) string {
+	if len(h.keys) == 0 {
+		return ""
+	}
+	underLimit := boundedLoad(h.nodes, factor, load)
+	n := h.search(key)
+	for i := 0; i < len(h.keys); i++ {
+		node := h.hashMap[h.keys[(n+i)%len(h.keys)]]
+		if underLimit(node) {
+			return node
+		}
+	}
+	return h.hashMap[h.keys[n]]
 }
 
 func (h *ConsistentHash) Del(key string) {
-	for i := 0; i < h.replicas; i++ {
+	weight, ok := h.nodes[key]
+	if !ok {
+		weight = 1
+	}
+	delete(h.nodes, key)
+	for i := 0; i < h.replicas*weight; i++ {
 		hashNumber := h.hash([]byte(strconv.Itoa(i) + key))
 		h.keys = removeElement(h.keys, int(hashNumber))
 		delete(h.hashMap, int(hashNumber))
@@ -70,3 +153,5 @@ func removeElement(slice []int, element int) []int {
 	}
 	return slice
 }
+
+var _ Placement = (*ConsistentHash)(nil)
@@ -2,6 +2,7 @@ package consistentHash
 
 import (
 	"fmt"
+	"hash/fnv"
 	"strconv"
 	"testing"
 )
@@ -64,3 +65,108 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+// maxAvg 按keys的顺序依次分配，每次分配都让结点的负载加一，返回最大负载与平均负载之比
+func maxAvg(keys []string, pick func(key string, loads map[string]int64) string) float64 {
+	loads := make(map[string]int64)
+	for _, key := range keys {
+		loads[pick(key, loads)]++
+	}
+	var max int64
+	for _, load := range loads {
+		if load > max {
+			max = load
+		}
+	}
+	return float64(max) / (float64(len(keys)) / 10)
+}
+
+func TestGetBounded(t *testing.T) {
+	hash := NewConsistentHash(DefaultReplicas, nil)
+	for i := 0; i < 10; i++ {
+		hash.Add(fmt.Sprintf("127.0.0.1:%d", 8000+i))
+	}
+	//todo 热点key出现的次数远多于其他key
+	keys := make([]string, 0, 10000)
+	for i := 0; len(keys) < cap(keys); i++ {
+		for j := 0; j < 1000/(i+1)+1 && len(keys) < cap(keys); j++ {
+			keys = append(keys, fmt.Sprintf("key%d", i))
+		}
+	}
+
+	const factor = 1.25
+	unbounded := maxAvg(keys, func(key string, loads map[string]int64) string {
+		return hash.Get(key)
+	})
+	bounded := maxAvg(keys, func(key string, loads map[string]int64) string {
+		return hash.GetBounded(key, factor, func(node string) int64 {
+			return loads[node]
+		})
+	})
+	t.Logf("max/avg unbounded %.2f bounded %.2f", unbounded, bounded)
+	if bounded > factor+0.01 {
+		t.Fatalf("bounded max/avg %.2f exceeds factor %.2f", bounded, factor)
+	}
+	if unbounded <= bounded {
+		t.Fatalf("skewed keys should overload a node without bounds, max/avg %.2f", unbounded)
+	}
+
+	//todo 没有负载时与Get相同
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if got := hash.GetBounded(key, factor, func(string) int64 { return 0 }); got != hash.Get(key) {
+			t.Fatalf("GetBounded(%s) = %s, want %s", key, got, hash.Get(key))
+		}
+	}
+}
+
+func fnv32(key []byte) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return h.Sum32()
+}
+
+// shares 返回每个结点分配到的key的比例以及每个key的owner
+func shares(hash *ConsistentHash) (map[string]float64, map[string]string) {
+	owners := make(map[string]string)
+	share := make(map[string]float64)
+	for i := 0; i < 10000; i++ {
+		key := "key" + strconv.Itoa(i)
+		owners[key] = hash.Get(key)
+		share[owners[key]] += 1.0 / 10000
+	}
+	return share, owners
+}
+
+func TestWeighted(t *testing.T) {
+	//todo crc32对相似的虚拟结点名分布很不均匀，这里用fnv
+	hash := NewConsistentHash(50, fnv32)
+	weights := map[string]int{"a": 1, "b": 2, "c": 4, "d": 8}
+	for node, weight := range weights {
+		hash.AddWeighted(node, weight)
+	}
+	share, before := shares(hash)
+	for node, weight := range weights {
+		want := float64(weight) / 15
+		if share[node] < want*0.75 || share[node] > want*1.25 {
+			t.Fatalf("%s with weight %d should own about %.2f of the keys, got %.2f", node, weight, want, share[node])
+		}
+	}
+
+	//todo 增加权重后只有key移动到a，其他结点之间不会互相移动
+	hash.SetWeight("a", 8)
+	share, after := shares(hash)
+	if share["a"] < 0.25 {
+		t.Fatalf("a should own more keys after its weight increases, got %.2f", share["a"])
+	}
+	for key, owner := range after {
+		if owner != before[key] && owner != "a" {
+			t.Fatalf("%s moved from %s to %s", key, before[key], owner)
+		}
+	}
+
+	hash.Del("a")
+	if hash.Weight("a") != 0 || len(hash.keys) != 50*14 {
+		t.Fatalf("a should be removed with all its virtual nodes, %d points left", len(hash.keys))
+	}
+}
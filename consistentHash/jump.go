@@ -0,0 +1,119 @@
+package consistentHash
+
+import "sort"
+
+// Jump jump consistent hash(Lamping & Veach)，不需要额外的内存，分布非常均匀
+// 桶按结点名排序，每个结点占weight个连续的桶，这样不同的结点不论以什么顺序加入结点，得到的桶都相同
+// jump只能在末尾增加或删除桶，所以只有排在最后的结点变化时移动的key最少，
+// 在中间加入或删除结点时排在它后面的桶都会换结点，结点经常变化时应该使用哈希环或Rendezvous
+type Jump struct {
+	hash Hash
+	//结点 -> 权重
+	nodes   map[string]int
+	buckets []string
+}
+
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = CRC32
+	}
+	return &Jump{
+		hash:  fn,
+		nodes: make(map[string]int),
+	}
+}
+
+func (j *Jump) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	j.nodes[node] = weight
+	j.rebuild()
+}
+
+func (j *Jump) Del(node string) {
+	if _, ok := j.nodes[node]; !ok {
+		return
+	}
+	delete(j.nodes, node)
+	j.rebuild()
+}
+
+// rebuild 按结点名的顺序重新排列桶，桶只取决于当前的结点和权重，与加入的顺序无关
+func (j *Jump) rebuild() {
+	nodes := make([]string, 0, len(j.nodes))
+	weights := 0
+	for node, weight := range j.nodes {
+		nodes = append(nodes, node)
+		weights += weight
+	}
+	sort.Strings(nodes)
+	j.buckets = make([]string, 0, weights)
+	for _, node := range nodes {
+		for i := 0; i < j.nodes[node]; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
+
+func (j *Jump) Weight(node string) int {
+	return j.nodes[node]
+}
+
+func (j *Jump) bucket(key string) int {
+	return jumpHash(mix64(uint64(j.hash([]byte(key)))), len(j.buckets))
+}
+
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[j.bucket(key)]
+}
+
+// GetN 从key所在的桶开始依次收集n个不同的结点
+// 注意删除结点后排在它后面的桶都会换结点，所以第一个结点被删除后它的key不一定由第二个结点负责
+func (j *Jump) GetN(key string, n int) []string {
+	if len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(j.nodes) {
+		n = len(j.nodes)
+	}
+	nodes := make([]string, 0, n)
+	start := j.bucket(key)
+	for i := 0; i < len(j.buckets) && len(nodes) < n; i++ {
+		if node := j.buckets[(start+i)%len(j.buckets)]; !contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// GetBounded 从key所在的桶开始依次跳过已经达到负载上限的结点
+func (j *Jump) GetBounded(key string, factor float64, load func(node string) int64) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	underLimit := boundedLoad(j.nodes, factor, load)
+	n := j.bucket(key)
+	for i := 0; i < len(j.buckets); i++ {
+		if node := j.buckets[(n+i)%len(j.buckets)]; underLimit(node) {
+			return node
+		}
+	}
+	return j.buckets[n]
+}
+
+// jumpHash 将key映射到[0, buckets)中的一个桶，桶的数量从n增加到n+1时只有1/(n+1)的key会移动到新的桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+var _ Placement = (*Jump)(nil)
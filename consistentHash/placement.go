@@ -0,0 +1,56 @@
+package consistentHash
+
+import (
+	"github.com/cespare/xxhash/v2"
+	"github.com/twmb/murmur3"
+	"hash/crc32"
+	"math"
+)
+
+// Placement 决定每个key由哪个结点负责，ConsistentHash(哈希环)、Rendezvous和Jump都实现了这个接口
+type Placement interface {
+	// AddWeighted 添加结点，weight小于1时按1处理，结点已经存在时修改它的权重
+	AddWeighted(node string, weight int)
+	// Del 删除结点
+	Del(node string)
+	// Weight 返回结点的权重，结点不存在时返回0
+	Weight(node string) int
+	// Get 返回负责key的结点，没有结点时返回""
+	Get(key string) string
+	// GetN 按优先级返回负责key的前n个不同的结点(preference list)，第一个与Get相同，结点不足n个时返回所有结点
+	GetN(key string, n int) []string
+	// GetBounded 带负载上限的Get，见boundedLoad
+	GetBounded(key string, factor float64, load func(node string) int64) string
+}
+
+// 可选的哈希函数，CRC32为默认值，对相似的字符串分布较差
+var (
+	CRC32   Hash = crc32.ChecksumIEEE
+	XXHash  Hash = func(data []byte) uint32 { return uint32(xxhash.Sum64(data)) }
+	Murmur3 Hash = murmur3.Sum32
+)
+
+// boundedLoad 返回判断结点是否还能接受一个请求的函数
+// 每个结点的负载上限为 ceil((总负载+1)*结点权重/总权重*factor)，factor应该大于1
+func boundedLoad(nodes map[string]int, factor float64, load func(node string) int64) func(node string) bool {
+	var total int64
+	weights := 0
+	for node, weight := range nodes {
+		total += load(node)
+		weights += weight
+	}
+	average := float64(total+1) / float64(weights)
+	return func(node string) bool {
+		return float64(load(node)+1) <= math.Ceil(average*float64(nodes[node])*factor)
+	}
+}
+
+// mix64 splitmix64的finalizer，把两个相关性较强的哈希值打散
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
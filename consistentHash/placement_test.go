@@ -0,0 +1,239 @@
+package consistentHash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+var placements = map[string]func(fn Hash) Placement{
+	"ring":       func(fn Hash) Placement { return NewConsistentHash(100, fn) },
+	"rendezvous": func(fn Hash) Placement { return NewRendezvous(fn) },
+	"jump":       func(fn Hash) Placement { return NewJump(fn) },
+}
+
+var hashes = map[string]Hash{
+	"crc32":   CRC32,
+	"xxhash":  XXHash,
+	"murmur3": Murmur3,
+}
+
+func owners(p Placement, keys int) map[string]string {
+	owner := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		owner[key] = p.Get(key)
+	}
+	return owner
+}
+
+// moved 返回owner发生变化的key的比例
+func moved(before, after map[string]string) float64 {
+	n := 0
+	for key, owner := range before {
+		if after[key] != owner {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+func TestPlacement(t *testing.T) {
+	const keys = 20000
+	for name, newPlacement := range placements {
+		for hashName, fn := range hashes {
+			t.Run(name+"/"+hashName, func(t *testing.T) {
+				p := newPlacement(fn)
+				if p.Get("key") != "" {
+					t.Fatal("empty placement should return nothing")
+				}
+				for i := 0; i < 10; i++ {
+					p.AddWeighted(fmt.Sprintf("127.0.0.1:%d", 8000+i), 1)
+				}
+				before := owners(p, keys)
+				count := make(map[string]int)
+				for _, owner := range before {
+					count[owner]++
+				}
+				for node, n := range count {
+					if share := float64(n) / keys; share < 0.05 || share > 0.15 {
+						t.Fatalf("%s owns %.3f of the keys, want about 0.1", node, share)
+					}
+				}
+
+				//todo 加入一个结点，大约1/11的key移动到新结点
+				p.AddWeighted("127.0.0.1:8010", 1)
+				after := owners(p, keys)
+				for key, owner := range after {
+					if owner != before[key] && owner != "127.0.0.1:8010" {
+						t.Fatalf("%s moved from %s to %s", key, before[key], owner)
+					}
+				}
+				if m := moved(before, after); m < 0.04 || m > 0.15 {
+					t.Fatalf("adding a node moved %.3f of the keys", m)
+				}
+
+				//todo 删除结点，jump中排在它后面的桶都会换结点
+				p.Del("127.0.0.1:8003")
+				removed := owners(p, keys)
+				limit := 0.15
+				if name == "jump" {
+					limit = 0.8
+				}
+				if m := moved(after, removed); m > limit {
+					t.Fatalf("removing a node moved %.3f of the keys", m)
+				}
+				for key, owner := range removed {
+					if owner == "127.0.0.1:8003" {
+						t.Fatalf("%s is still owned by the removed node", key)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestPlacementOrder 不同的结点以不同的顺序加入相同的结点，必须对每个key的owner达成一致
+func TestPlacementOrder(t *testing.T) {
+	const keys = 10000
+	for name, newPlacement := range placements {
+		t.Run(name, func(t *testing.T) {
+			nodes := make([]string, 10)
+			for i := range nodes {
+				nodes[i] = fmt.Sprintf("127.0.0.1:%d", 8000+i)
+			}
+			forward, backward := newPlacement(XXHash), newPlacement(XXHash)
+			for i := range nodes {
+				forward.AddWeighted(nodes[i], 1+i%3)
+				backward.AddWeighted(nodes[len(nodes)-1-i], 1+(len(nodes)-1-i)%3)
+			}
+			//todo 自己先加入，再删除并重新加入其他结点
+			rejoined := newPlacement(XXHash)
+			rejoined.AddWeighted(nodes[5], 1+5%3)
+			for i := range nodes {
+				rejoined.AddWeighted(nodes[i], 1)
+			}
+			rejoined.Del(nodes[2])
+			for i := range nodes {
+				rejoined.AddWeighted(nodes[i], 1+i%3)
+			}
+
+			want := owners(forward, keys)
+			for order, p := range map[string]Placement{"backward": backward, "rejoined": rejoined} {
+				if m := moved(want, owners(p, keys)); m != 0 {
+					t.Fatalf("%s order disagrees on %.3f of the keys", order, m)
+				}
+			}
+			for i := 0; i < 100; i++ {
+				key := "key" + strconv.Itoa(i)
+				if a, b := fmt.Sprint(forward.GetN(key, 3)), fmt.Sprint(backward.GetN(key, 3)); a != b {
+					t.Fatalf("GetN(%s) = %s and %s", key, a, b)
+				}
+			}
+		})
+	}
+}
+
+func TestPlacementWeighted(t *testing.T) {
+	for name, newPlacement := range placements {
+		t.Run(name, func(t *testing.T) {
+			p := newPlacement(XXHash)
+			p.AddWeighted("small", 1)
+			p.AddWeighted("big", 3)
+			if p.Weight("big") != 3 || p.Weight("unknown") != 0 {
+				t.Fatalf("unexpected weights %d %d", p.Weight("big"), p.Weight("unknown"))
+			}
+			big := 0
+			for _, owner := range owners(p, 20000) {
+				if owner == "big" {
+					big++
+				}
+			}
+			if share := float64(big) / 20000; share < 0.65 || share > 0.85 {
+				t.Fatalf("big should own about 75%% of the keys, got %.3f", share)
+			}
+		})
+	}
+}
+
+func TestPlacementBounded(t *testing.T) {
+	for name, newPlacement := range placements {
+		t.Run(name, func(t *testing.T) {
+			p := newPlacement(XXHash)
+			for i := 0; i < 10; i++ {
+				p.AddWeighted(strconv.Itoa(i), 1)
+			}
+			loads := make(map[string]int64)
+			load := func(node string) int64 {
+				return loads[node]
+			}
+			//todo 所有请求都是同一个key
+			for i := 0; i < 1000; i++ {
+				loads[p.GetBounded("hot", 1.25, load)]++
+			}
+			for node, n := range loads {
+				if n > 125 {
+					t.Fatalf("%s has load %d, exceeds 1.25 times the average", node, n)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for name, newPlacement := range placements {
+		b.Run(name, func(b *testing.B) {
+			p := newPlacement(XXHash)
+			for i := 0; i < 50; i++ {
+				p.AddWeighted(fmt.Sprintf("127.0.0.1:%d", 8000+i), 1)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Get("key" + strconv.Itoa(i))
+			}
+		})
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	for name, newPlacement := range placements {
+		t.Run(name, func(t *testing.T) {
+			p := newPlacement(XXHash)
+			if p.GetN("key", 3) != nil {
+				t.Fatal("empty placement should return nothing")
+			}
+			for i := 0; i < 5; i++ {
+				p.AddWeighted(strconv.Itoa(i), 1+i%2)
+			}
+			if all := p.GetN("key", 10); len(all) != 5 {
+				t.Fatalf("GetN should return all 5 nodes, got %v", all)
+			}
+			for i := 0; i < 100; i++ {
+				key := "key" + strconv.Itoa(i)
+				nodes := p.GetN(key, 3)
+				if len(nodes) != 3 || nodes[0] != p.Get(key) {
+					t.Fatalf("GetN(%s) = %v, Get = %s", key, nodes, p.Get(key))
+				}
+				if nodes[0] == nodes[1] || nodes[1] == nodes[2] || nodes[0] == nodes[2] {
+					t.Fatalf("GetN(%s) = %v should be distinct", key, nodes)
+				}
+			}
+
+			if name == "jump" {
+				return
+			}
+			//todo 首选结点被删除后由第二个结点负责
+			before := make(map[string][]string)
+			for i := 0; i < 100; i++ {
+				key := "key" + strconv.Itoa(i)
+				before[key] = p.GetN(key, 2)
+			}
+			p.Del("0")
+			for key, nodes := range before {
+				if nodes[0] == "0" && p.Get(key) != nodes[1] {
+					t.Fatalf("%s should move to %s, got %s", key, nodes[1], p.Get(key))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package consistentHash
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous 最高随机权重哈希(HRW)，每个key对所有结点打分，由分数最高的结点负责
+// 不需要虚拟结点，分布只取决于哈希函数，结点变化时只有属于这个结点的key会移动，代价是Get需要遍历所有结点
+type Rendezvous struct {
+	hash Hash
+	//结点 -> 权重
+	nodes map[string]int
+	//结点名的哈希，打分时与key的哈希组合，避免每次都对结点名做哈希
+	seeds map[string]uint64
+}
+
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = CRC32
+	}
+	return &Rendezvous{
+		hash:  fn,
+		nodes: make(map[string]int),
+		seeds: make(map[string]uint64),
+	}
+}
+
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	r.nodes[node] = weight
+	r.seeds[node] = uint64(r.hash([]byte(node)))
+}
+
+func (r *Rendezvous) Del(node string) {
+	delete(r.nodes, node)
+	delete(r.seeds, node)
+}
+
+func (r *Rendezvous) Weight(node string) int {
+	return r.nodes[node]
+}
+
+// score 带权重的打分(logarithmic method)：weight / -ln(u)，u为(0,1)上均匀分布的随机数
+// 结点分到的key的比例与权重成正比
+func (r *Rendezvous) score(node string, keyHash uint64) float64 {
+	u := (float64(mix64(r.seeds[node]<<32|keyHash)>>11) + 0.5) / (1 << 53)
+	return float64(r.nodes[node]) / -math.Log(u)
+}
+
+func (r *Rendezvous) Get(key string) string {
+	keyHash := uint64(r.hash([]byte(key)))
+	best, bestScore := "", 0.0
+	for node := range r.nodes {
+		//todo 分数相同时按结点名比较，保证结果与map的遍历顺序无关
+		if score := r.score(node, keyHash); best == "" || score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// GetN 返回分数最高的n个结点，第一个结点被删除后它的key正好由第二个结点负责
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	nodes := r.ranked(key)
+	if n < len(nodes) {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// GetBounded 按分数从高到低跳过已经达到负载上限的结点
+func (r *Rendezvous) GetBounded(key string, factor float64, load func(node string) int64) string {
+	if len(r.nodes) == 0 {
+		return ""
+	}
+	nodes := r.ranked(key)
+	underLimit := boundedLoad(r.nodes, factor, load)
+	for _, node := range nodes {
+		if underLimit(node) {
+			return node
+		}
+	}
+	return nodes[0]
+}
+
+// ranked 将所有结点按分数从高到低排序
+func (r *Rendezvous) ranked(key string) []string {
+	keyHash := uint64(r.hash([]byte(key)))
+	nodes := make([]string, 0, len(r.nodes))
+	scores := make(map[string]float64, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+		scores[node] = r.score(node, keyHash)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if scores[nodes[i]] == scores[nodes[j]] {
+			return nodes[i] < nodes[j]
+		}
+		return scores[nodes[i]] > scores[nodes[j]]
+	})
+	return nodes
+}
+
+var _ Placement = (*Rendezvous)(nil)
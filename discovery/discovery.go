@@ -0,0 +1,50 @@
+package discovery
+
+import "context"
+
+type EventType int
+
+const (
+	// Add 结点加入
+	Add EventType = iota
+	// Remove 结点离开
+	Remove
+)
+
+func (t EventType) String() string {
+	if t == Add {
+		return "add"
+	}
+	return "remove"
+}
+
+// Peer 一个缓存结点
+type Peer struct {
+	// Addr 结点地址，例如"127.0.0.1:8000"
+	Addr string `json:"addr" yaml:"addr"`
+	// Weight 权重，结点在哈希环上的虚拟结点数与权重成正比，0表示默认的1
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// Event 结点变化事件
+type Event struct {
+	Type EventType
+	// Key 结点注册时使用的key，static和file中就是Addr
+	Key  string
+	Peer Peer
+}
+
+// Discovery 服务发现，负责把自己注册到注册中心并监听其他结点的变化
+type Discovery interface {
+	// Register 将self注册到注册中心
+	Register(ctx context.Context, self Peer) error
+	// Deregister 删除Register注册的结点
+	Deregister(ctx context.Context) error
+	// Watch 先为当前已有的结点发送Add事件，之后持续发送结点变化，ctx被取消后关闭channel
+	Watch(ctx context.Context) (<-chan Event, error)
+}
+
+// WeightSetter 支持在运行时修改已注册结点权重的Discovery，其他结点会收到一个相同key的Add事件
+type WeightSetter interface {
+	SetWeight(ctx context.Context, weight int) error
+}
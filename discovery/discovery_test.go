@@ -0,0 +1,232 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, events <-chan Event, n int) []Event {
+	t.Helper()
+	got := make([]Event, 0, n)
+	for len(got) < n {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("channel closed after %d events, want %d", len(got), n)
+			}
+			got = append(got, event)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout after %d events, want %d", len(got), n)
+		}
+	}
+	sort.Slice(got, func(i, j int) bool {
+		return got[i].Peer.Addr < got[j].Peer.Addr
+	})
+	return got
+}
+
+func TestStatic(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	s := NewStatic("127.0.0.1:8001", "127.0.0.1:8002")
+	if err := s.Register(ctx, Peer{Addr: "127.0.0.1:8001"}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := collect(t, events, 2)
+	if got[0].Type != Add || got[0].Peer.Addr != "127.0.0.1:8001" || got[1].Peer.Addr != "127.0.0.1:8002" {
+		t.Fatalf("unexpected events %v", got)
+	}
+	cancelFunc()
+	if _, ok := <-events; ok {
+		t.Fatal("channel should be closed after ctx is cancelled")
+	}
+}
+
+func testFile(t *testing.T, name, first, second string) {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(first), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	f := NewFile(path, 10*time.Millisecond)
+	events, err := f.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := collect(t, events, 2)
+	if got[0].Peer.Addr != "127.0.0.1:8001" || got[1].Peer.Addr != "127.0.0.1:8002" {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	//todo 保证修改时间发生变化
+	time.Sleep(20 * time.Millisecond)
+	if err = os.WriteFile(path, []byte(second), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Second)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	got = collect(t, events, 2)
+	if got[0].Type != Remove || got[0].Peer.Addr != "127.0.0.1:8001" {
+		t.Fatalf("8001 should be removed, got %v", got[0])
+	}
+	if got[1].Type != Add || got[1].Peer.Addr != "127.0.0.1:8003" {
+		t.Fatalf("8003 should be added, got %v", got[1])
+	}
+}
+
+func TestFileWeight(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.yaml")
+	if err := os.WriteFile(path, []byte("peers:\n  - addr: 127.0.0.1:8001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	events, err := NewFile(path, 10*time.Millisecond).Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, events, 1); got[0].Peer.Weight != 0 {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	if err = os.WriteFile(path, []byte("peers:\n  - addr: 127.0.0.1:8001\n    weight: 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Second)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	//todo 权重变化以相同的key重新发送Add
+	if got := collect(t, events, 1); got[0].Type != Add || got[0].Key != "127.0.0.1:8001" || got[0].Peer.Weight != 4 {
+		t.Fatalf("weight change should be sent as an add event, got %v", got[0])
+	}
+}
+
+func TestFileJSON(t *testing.T) {
+	testFile(t, "peers.json",
+		`{"peers": [{"addr": "127.0.0.1:8001"}, {"addr": "127.0.0.1:8002"}]}`,
+		`{"peers": [{"addr": "127.0.0.1:8002"}, {"addr": "127.0.0.1:8003"}]}`)
+}
+
+func TestFileYAML(t *testing.T) {
+	testFile(t, "peers.yaml",
+		"peers:\n  - addr: 127.0.0.1:8001\n  - addr: 127.0.0.1:8002\n",
+		"peers:\n  - addr: 127.0.0.1:8002\n  - addr: 127.0.0.1:8003\n")
+}
+
+// syncBuffer 可以被多个goroutine同时写入的日志输出
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	if err := os.WriteFile(path, []byte(`{"peers": [{"addr": "127.0.0.1:8001"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	out := &syncBuffer{}
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	events, err := NewFile(path, 10*time.Millisecond, WithFileLogger(logger)).Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, events, 1)
+
+	//todo 文件内容错误时输出Warn日志并保留原来的结点
+	if err = os.WriteFile(path, []byte(`{"peers": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(time.Second)
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "load peers failed") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect a warning for the broken file, got %q", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), "level=WARN") || !strings.Contains(out.String(), "path="+path) {
+		t.Fatalf("warning should be structured, got %q", out.String())
+	}
+}
+
+func TestFileNotExist(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "missing.json"), 0)
+	if _, err := f.Watch(context.Background()); err == nil {
+		t.Fatal("watching a missing file should fail")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	a, b := m.Client(), m.Client()
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	if err := a.Register(ctx, Peer{Addr: "127.0.0.1:8001"}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := b.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, events, 1); got[0].Type != Add || got[0].Peer.Addr != "127.0.0.1:8001" {
+		t.Fatalf("unexpected events %v", got)
+	}
+	if err = b.Register(ctx, Peer{Addr: "127.0.0.1:8002"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.SetWeight(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	got := collect(t, events, 2)
+	if got[0].Peer.Weight != 3 || got[1].Type != Add || got[1].Peer.Addr != "127.0.0.1:8002" {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	//todo a崩溃后其他结点收到删除事件
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got = collect(t, events, 1); got[0].Type != Remove || got[0].Key != "127.0.0.1:8001" {
+		t.Fatalf("a should be removed, got %v", got[0])
+	}
+	if peers := m.Peers(); len(peers) != 1 || peers[0].Addr != "127.0.0.1:8002" {
+		t.Fatalf("only b should remain, got %v", peers)
+	}
+	if err = a.Register(ctx, Peer{Addr: "127.0.0.1:8001"}); err == nil {
+		t.Fatal("a closed client should not register again")
+	}
+	cancelFunc()
+	for range events {
+	}
+}
@@ -0,0 +1,157 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultFileInterval = time.Second * 3
+
+// File 从JSON或YAML文件中读取结点列表，并定期检查文件是否被修改
+// 根据扩展名判断格式，.yaml/.yml为YAML，其余按JSON解析，例如:
+//
+//	{"peers": [{"addr": "127.0.0.1:8001"}, {"addr": "127.0.0.1:8002"}]}
+//
+// 文件由运维维护，Register和Deregister什么都不做
+type File struct {
+	path     string
+	interval time.Duration
+	logger   *slog.Logger
+}
+
+type FileOption func(*File)
+
+// WithFileLogger 设置File的日志，默认不输出日志
+func WithFileLogger(logger *slog.Logger) FileOption {
+	return func(f *File) {
+		f.logger = logger
+	}
+}
+
+type fileConfig struct {
+	Peers []Peer `json:"peers" yaml:"peers"`
+}
+
+// NewFile interval为检查文件修改的间隔，为0时使用defaultFileInterval
+func NewFile(path string, interval time.Duration, opts ...FileOption) *File {
+	if interval == 0 {
+		interval = defaultFileInterval
+	}
+	f := &File{path: path, interval: interval}
+	for _, opt := range opts {
+		opt(f)
+	}
+	f.logger = logging.OrDiscard(f.logger).With("discovery", "file", "path", path)
+	return f
+}
+
+func (f *File) Register(ctx context.Context, self Peer) error {
+	return nil
+}
+
+func (f *File) Deregister(ctx context.Context) error {
+	return nil
+}
+
+func (f *File) Watch(ctx context.Context) (<-chan Event, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		current := make(map[string]Peer)
+		modTime := info.ModTime()
+		if !f.diff(ctx, events, current, peers) {
+			return
+		}
+
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(f.path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			peers, err := f.load()
+			if err != nil {
+				//todo 文件可能正在被写入，下次再读
+				f.logger.Warn("load peers failed, retry later", "err", err)
+				continue
+			}
+			modTime = info.ModTime()
+			if !f.diff(ctx, events, current, peers) {
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (f *File) load() ([]Peer, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	config := &fileConfig{}
+	switch filepath.Ext(f.path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, config)
+	default:
+		err = json.Unmarshal(data, config)
+	}
+	return config.Peers, err
+}
+
+// diff 对比current和peers，发送变化的事件并更新current，ctx被取消时返回false
+func (f *File) diff(ctx context.Context, events chan<- Event, current map[string]Peer, peers []Peer) bool {
+	next := make(map[string]Peer, len(peers))
+	for _, peer := range peers {
+		next[peer.Addr] = peer
+	}
+	send := func(event Event) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for addr, peer := range current {
+		if _, ok := next[addr]; !ok {
+			if !send(Event{Type: Remove, Key: addr, Peer: peer}) {
+				return false
+			}
+			delete(current, addr)
+		}
+	}
+	//todo 权重变化时以相同的key重新发送Add
+	for addr, peer := range next {
+		if old, ok := current[addr]; !ok || old != peer {
+			if !send(Event{Type: Add, Key: addr, Peer: peer}) {
+				return false
+			}
+			current[addr] = peer
+		}
+	}
+	return true
+}
+
+var _ Discovery = (*File)(nil)
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Memory 进程内的注册中心，多个结点通过各自的MemoryClient注册和发现彼此，适合在一个进程中测试多个结点
+// key就是结点的Addr，与Static和File相同
+type Memory struct {
+	mu       sync.Mutex
+	peers    map[string]Peer
+	watchers map[*memoryWatcher]struct{}
+}
+
+type memoryWatcher struct {
+	events chan Event
+	//Watch的ctx被取消或者MemoryClient被关闭
+	done chan struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		peers:    make(map[string]Peer),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+// Client 返回一个结点使用的Discovery
+func (m *Memory) Client() *MemoryClient {
+	return &MemoryClient{memory: m, closed: make(chan struct{})}
+}
+
+// Peers 返回当前注册的结点，按地址排序
+func (m *Memory) Peers() []Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peers := make([]Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Addr < peers[j].Addr
+	})
+	return peers
+}
+
+func (m *Memory) put(peer Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers[peer.Addr] = peer
+	m.publish(Event{Type: Add, Key: peer.Addr, Peer: peer})
+}
+
+func (m *Memory) remove(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peer, ok := m.peers[key]
+	if !ok {
+		return
+	}
+	delete(m.peers, key)
+	m.publish(Event{Type: Remove, Key: key, Peer: peer})
+}
+
+// publish 调用方持有m.mu，watcher停止后不再阻塞
+func (m *Memory) publish(event Event) {
+	for w := range m.watchers {
+		select {
+		case w.events <- event:
+		case <-w.done:
+		}
+	}
+}
+
+func (m *Memory) watch(ctx context.Context, closed <-chan struct{}) <-chan Event {
+	m.mu.Lock()
+	w := &memoryWatcher{events: make(chan Event, len(m.peers)+16), done: make(chan struct{})}
+	for key, peer := range m.peers {
+		w.events <- Event{Type: Add, Key: key, Peer: peer}
+	}
+	m.watchers[w] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-closed:
+		}
+		close(w.done)
+		m.mu.Lock()
+		delete(m.watchers, w)
+		m.mu.Unlock()
+		close(w.events)
+	}()
+	return w.events
+}
+
+// MemoryClient 一个结点在Memory中的注册
+type MemoryClient struct {
+	memory *Memory
+
+	mu        sync.Mutex
+	self      Peer
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *MemoryClient) Register(ctx context.Context, self Peer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return errors.New("memory discovery client is closed")
+	default:
+	}
+	c.self = self
+	c.memory.put(self)
+	return nil
+}
+
+func (c *MemoryClient) Deregister(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.self.Addr != "" {
+		c.memory.remove(c.self.Addr)
+		c.self = Peer{}
+	}
+	return nil
+}
+
+func (c *MemoryClient) Watch(ctx context.Context) (<-chan Event, error) {
+	return c.memory.watch(ctx, c.closed), nil
+}
+
+func (c *MemoryClient) SetWeight(ctx context.Context, weight int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.self.Addr == "" {
+		return errors.New("not registered")
+	}
+	c.self.Weight = weight
+	c.memory.put(c.self)
+	return nil
+}
+
+// Close 模拟结点崩溃后租约过期：删除注册的结点并关闭Watch返回的channel
+func (c *MemoryClient) Close() error {
+	_ = c.Deregister(context.Background())
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+var (
+	_ Discovery    = (*MemoryClient)(nil)
+	_ WeightSetter = (*MemoryClient)(nil)
+)
@@ -0,0 +1,39 @@
+package discovery
+
+import "context"
+
+// Static 固定的结点列表，适合本地开发和测试
+// 结点由调用方指定，Register和Deregister什么都不做
+type Static struct {
+	peers []Peer
+}
+
+func NewStatic(addrs ...string) *Static {
+	peers := make([]Peer, 0, len(addrs))
+	for _, addr := range addrs {
+		peers = append(peers, Peer{Addr: addr})
+	}
+	return &Static{peers: peers}
+}
+
+func (s *Static) Register(ctx context.Context, self Peer) error {
+	return nil
+}
+
+func (s *Static) Deregister(ctx context.Context) error {
+	return nil
+}
+
+func (s *Static) Watch(ctx context.Context) (<-chan Event, error) {
+	events := make(chan Event, len(s.peers))
+	for _, peer := range s.peers {
+		events <- Event{Type: Add, Key: peer.Addr, Peer: peer}
+	}
+	go func() {
+		<-ctx.Done()
+		close(events)
+	}()
+	return events, nil
+}
+
+var _ Discovery = (*Static)(nil)
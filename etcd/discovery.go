@@ -0,0 +1,335 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPrefix         = "Cache&"
+	defaultWatcherTime    = time.Second * 3
+	defaultRequestTimeout = time.Second * 5
+	defaultTTL            = time.Second * 10
+)
+
+// Discovery 基于etcd的服务发现，每个结点注册为prefix+时间戳+随机数 -> JSON编码的discovery.Peer
+// 为了兼容旧版本的结点，值不是JSON时当作地址处理
+// 注册的key绑定在一个ttl的租约上并不断续约，结点崩溃或被kill之后租约过期，key会被etcd自动删除
+type Discovery struct {
+	client      *clientv3.Client
+	prefix      string
+	watcherTime time.Duration
+	ttl         time.Duration
+	logger      *slog.Logger
+
+	mu sync.Mutex
+	//Register时生成的key
+	key     string
+	self    discovery.Peer
+	leaseID clientv3.LeaseID
+	//停止续约，keepAliveDone在续约的goroutine退出后关闭
+	stopKeepAlive context.CancelFunc
+	keepAliveDone chan struct{}
+}
+
+type Option func(*Discovery)
+
+// WithLogger 设置Discovery的日志，默认不输出日志
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *Discovery) {
+		d.logger = logger
+	}
+}
+
+// NewDiscovery watcherTime为watch断开后重新watch(以及重新注册)的间隔，为0时使用defaultWatcherTime
+// ttl为租约的过期时间，为0时使用defaultTTL
+func NewDiscovery(client *clientv3.Client, watcherTime time.Duration, ttl time.Duration, opts ...Option) *Discovery {
+	if watcherTime == 0 {
+		watcherTime = defaultWatcherTime
+	}
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	d := &Discovery{
+		client:      client,
+		prefix:      DefaultPrefix,
+		watcherTime: watcherTime,
+		ttl:         ttl,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.logger = logging.OrDiscard(d.logger).With("discovery", "etcd")
+	return d
+}
+
+func (d *Discovery) Register(ctx context.Context, self discovery.Peer) error {
+	if d.client == nil {
+		return errors.New("etcd client is nil")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopKeepAlive != nil {
+		return errors.New("already registered")
+	}
+	d.key = d.prefix + strconv.Itoa(int(time.Now().Unix())) + strconv.Itoa(rand.Intn(1000000))
+	d.self = self
+	leaseID, err := d.put(ctx, self)
+	if err != nil {
+		return err
+	}
+	d.leaseID = leaseID
+
+	keepCtx, cancelFunc := context.WithCancel(context.Background())
+	d.stopKeepAlive = cancelFunc
+	d.keepAliveDone = make(chan struct{})
+	go d.keepAlive(keepCtx, leaseID)
+	return nil
+}
+
+// put 申请一个新的租约并把key绑定在上面
+func (d *Discovery) put(ctx context.Context, self discovery.Peer) (clientv3.LeaseID, error) {
+	seconds := int64(d.ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	lease, err := d.client.Grant(ctx, seconds)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = d.client.Put(ctx, d.key, encodePeer(self), clientv3.WithLease(lease.ID)); err != nil {
+		return 0, err
+	}
+	return lease.ID, nil
+}
+
+// keepAlive 不断续约，租约丢失(例如与etcd断开的时间超过了ttl)后重新注册
+func (d *Discovery) keepAlive(ctx context.Context, leaseID clientv3.LeaseID) {
+	defer close(d.keepAliveDone)
+	for {
+		responses, err := d.client.KeepAlive(ctx, leaseID)
+		if err == nil {
+			//todo channel被关闭说明租约已经过期或者ctx被取消
+			for range responses {
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		d.logger.Warn("lease lost, register again", "key", d.key)
+
+		for {
+			d.mu.Lock()
+			self := d.self
+			d.mu.Unlock()
+			timeout, cancelFunc := context.WithTimeout(ctx, defaultRequestTimeout)
+			leaseID, err = d.put(timeout, self)
+			cancelFunc()
+			if err == nil {
+				break
+			}
+			d.logger.Warn("register again failed", "key", d.key, "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.watcherTime):
+			}
+		}
+		d.mu.Lock()
+		d.leaseID = leaseID
+		d.mu.Unlock()
+	}
+}
+
+// SetWeight 修改已注册结点的权重，在原来的租约上重新写入key，watch的结点会收到一个Add事件
+func (d *Discovery) SetWeight(ctx context.Context, weight int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopKeepAlive == nil {
+		return errors.New("not registered")
+	}
+	d.self.Weight = weight
+	_, err := d.client.Put(ctx, d.key, encodePeer(d.self), clientv3.WithLease(d.leaseID))
+	return err
+}
+
+// Deregister 停止续约并撤销租约，etcd会删除租约上的key
+func (d *Discovery) Deregister(ctx context.Context) error {
+	d.mu.Lock()
+	stopKeepAlive, done := d.stopKeepAlive, d.keepAliveDone
+	d.stopKeepAlive = nil
+	d.mu.Unlock()
+	if stopKeepAlive == nil {
+		return nil
+	}
+	//todo 等续约的goroutine退出，避免它在撤销之后又重新注册
+	stopKeepAlive()
+	<-done
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.client.Revoke(ctx, d.leaseID); err != nil {
+		//todo 租约可能已经过期，直接删除key
+		_, err = d.client.Delete(ctx, d.key)
+		return err
+	}
+	return nil
+}
+
+func (d *Discovery) Watch(ctx context.Context) (<-chan discovery.Event, error) {
+	if d.client == nil {
+		return nil, errors.New("etcd client is nil")
+	}
+	timeout, cancelFunc := context.WithTimeout(ctx, defaultRequestTimeout)
+	defer cancelFunc()
+	get, err := d.client.Get(timeout, d.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan discovery.Event, len(get.Kvs))
+	known := make(map[string]discovery.Peer, len(get.Kvs))
+	for _, kv := range get.Kvs {
+		peer := decodePeer(kv.Value)
+		known[string(kv.Key)] = peer
+		events <- discovery.Event{Type: discovery.Add, Key: string(kv.Key), Peer: peer}
+	}
+	//todo 从Get之后的版本开始监听，避免漏掉或重复事件
+	go d.watch(ctx, get.Header.Revision+1, known, events)
+	return events, nil
+}
+
+// watch 从rev开始监听prefix，known记录已经通知过的结点
+// rev被压缩时中间的事件已经丢失，重新list并与known比较，补发Add/Remove事件后从list的版本继续监听
+func (d *Discovery) watch(ctx context.Context, rev int64, known map[string]discovery.Peer, events chan<- discovery.Event) {
+	defer close(events)
+	defer d.logger.Debug("watch finished", "prefix", d.prefix)
+	send := func(e discovery.Event) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		resynced := false
+		watchCtx, cancelWatch := context.WithCancel(ctx)
+		watcher := d.client.Watch(watchCtx, d.prefix, clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(rev))
+		for resp := range watcher {
+			if resp.CompactRevision != 0 {
+				d.logger.Warn("watch revision compacted, list peers again", "revision", rev, "compact_revision", resp.CompactRevision)
+				next, err := d.resync(ctx, known, send)
+				if err != nil {
+					d.logger.Warn("list peers failed", "err", err)
+				} else {
+					rev, resynced = next, true
+				}
+				break
+			}
+			//todo watch被取消或者出错时没有处理任何事件，rev保持不变，稍后从同一个版本重新监听
+			if err := resp.Err(); err != nil || resp.Canceled {
+				d.logger.Warn("watch failed, retry", "revision", rev, "canceled", resp.Canceled, "err", err)
+				break
+			}
+			for _, event := range resp.Events {
+				e := discovery.Event{Key: string(event.Kv.Key)}
+				switch event.Type {
+				case clientv3.EventTypePut:
+					d.logger.Debug("watch put", "key", e.Key, "revision", event.Kv.ModRevision)
+					e.Type = discovery.Add
+					e.Peer = decodePeer(event.Kv.Value)
+					known[e.Key] = e.Peer
+				case clientv3.EventTypeDelete:
+					d.logger.Debug("watch delete", "key", e.Key, "revision", event.Kv.ModRevision)
+					e.Type = discovery.Remove
+					if event.PrevKv != nil {
+						e.Peer = decodePeer(event.PrevKv.Value)
+					} else {
+						e.Peer = known[e.Key]
+					}
+					delete(known, e.Key)
+				}
+				if !send(e) {
+					cancelWatch()
+					return
+				}
+			}
+			rev = resp.Header.Revision + 1
+		}
+		cancelWatch()
+		if resynced {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.watcherTime):
+		}
+	}
+}
+
+// resync 重新list prefix，与known比较后发送Add/Remove事件并更新known，返回之后应该开始监听的版本
+func (d *Discovery) resync(ctx context.Context, known map[string]discovery.Peer, send func(discovery.Event) bool) (int64, error) {
+	timeout, cancelFunc := context.WithTimeout(ctx, defaultRequestTimeout)
+	defer cancelFunc()
+	get, err := d.client.Get(timeout, d.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	current := make(map[string]discovery.Peer, len(get.Kvs))
+	for _, kv := range get.Kvs {
+		key, peer := string(kv.Key), decodePeer(kv.Value)
+		current[key] = peer
+		if old, ok := known[key]; ok && old == peer {
+			continue
+		}
+		if !send(discovery.Event{Type: discovery.Add, Key: key, Peer: peer}) {
+			return 0, ctx.Err()
+		}
+		known[key] = peer
+	}
+	var removed []string
+	for key := range known {
+		if _, ok := current[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	for _, key := range removed {
+		if !send(discovery.Event{Type: discovery.Remove, Key: key, Peer: known[key]}) {
+			return 0, ctx.Err()
+		}
+		delete(known, key)
+	}
+	return get.Header.Revision + 1, nil
+}
+
+func encodePeer(peer discovery.Peer) string {
+	data, _ := json.Marshal(peer)
+	return string(data)
+}
+
+// decodePeer 旧版本的结点直接把地址作为值
+func decodePeer(value []byte) discovery.Peer {
+	peer := discovery.Peer{}
+	if err := json.Unmarshal(value, &peer); err != nil || peer.Addr == "" {
+		return discovery.Peer{Addr: string(value)}
+	}
+	return peer
+}
+
+var (
+	_ discovery.Discovery    = (*Discovery)(nil)
+	_ discovery.WeightSetter = (*Discovery)(nil)
+)
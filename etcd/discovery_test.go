@@ -0,0 +1,311 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	u, _ := url.Parse("http://" + lis.Addr().String())
+	return *u
+}
+
+// startEtcd 启动一个内嵌的单结点etcd，返回连接到它的client
+func startEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("embedded etcd took too long to start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.Host},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool, format string, args ...any) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func registered(t *testing.T, client *clientv3.Client, key string) (clientv3.LeaseID, bool) {
+	get, err := client.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(get.Kvs) == 0 {
+		return 0, false
+	}
+	return clientv3.LeaseID(get.Kvs[0].Lease), true
+}
+
+func TestLeaseRegister(t *testing.T) {
+	client := startEtcd(t)
+	ctx := context.Background()
+	d := NewDiscovery(client, 100*time.Millisecond, 2*time.Second)
+	if err := d.Register(ctx, discovery.Peer{Addr: "127.0.0.1:8001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	leaseID, ok := registered(t, client, d.key)
+	if !ok || leaseID == 0 {
+		t.Fatalf("%s should be registered with a lease", d.key)
+	}
+
+	//todo 超过ttl之后依然存在，说明在续约
+	time.Sleep(3 * time.Second)
+	if _, ok = registered(t, client, d.key); !ok {
+		t.Fatalf("%s should be kept alive", d.key)
+	}
+
+	//todo 模拟租约丢失，应该重新注册
+	if _, err := client.Revoke(ctx, leaseID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		newLeaseID, ok := registered(t, client, d.key)
+		return ok && newLeaseID != leaseID
+	}, "%s should be registered again after the lease is lost", d.key)
+
+	if err := d.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = registered(t, client, d.key); ok {
+		t.Fatalf("%s should be removed after Deregister", d.key)
+	}
+}
+
+func TestLeaseExpire(t *testing.T) {
+	client := startEtcd(t)
+	ctx := context.Background()
+	d := NewDiscovery(client, 100*time.Millisecond, 2*time.Second)
+	if err := d.Register(ctx, discovery.Peer{Addr: "127.0.0.1:8001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	//todo 模拟进程被kill，不再续约也不会Deregister
+	d.stopKeepAlive()
+	<-d.keepAliveDone
+	waitFor(t, 10*time.Second, func() bool {
+		_, ok := registered(t, client, d.key)
+		return !ok
+	}, "%s should expire without keepalive", d.key)
+}
+
+func TestWatch(t *testing.T) {
+	client := startEtcd(t)
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	first := NewDiscovery(client, 100*time.Millisecond, 2*time.Second)
+	if err := first.Register(ctx, discovery.Peer{Addr: "127.0.0.1:8001"}); err != nil {
+		t.Fatal(err)
+	}
+	watcher := NewDiscovery(client, 100*time.Millisecond, 2*time.Second)
+	events, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewDiscovery(client, 100*time.Millisecond, 2*time.Second)
+	if err = second.Register(ctx, discovery.Peer{Addr: "127.0.0.1:8002"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		fmt.Sprintf("add %s 127.0.0.1:8001", first.key),
+		fmt.Sprintf("add %s 127.0.0.1:8002", second.key),
+		fmt.Sprintf("remove %s 127.0.0.1:8001", first.key),
+	}
+	for _, want := range expect {
+		select {
+		case event := <-events:
+			if got := fmt.Sprintf("%s %s %s", event.Type, event.Key, event.Peer.Addr); got != want {
+				t.Fatalf("got event %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+func TestWatchCompacted(t *testing.T) {
+	client := startEtcd(t)
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	put := func(key, addr string) int64 {
+		resp, err := client.Put(ctx, DefaultPrefix+key, encodePeer(discovery.Peer{Addr: addr}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Header.Revision
+	}
+	rev := put("a", "127.0.0.1:8001")
+	put("b", "127.0.0.1:8002")
+	resp, err := client.Delete(ctx, DefaultPrefix+"a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Compact(ctx, resp.Header.Revision); err != nil {
+		t.Fatal(err)
+	}
+
+	//todo watcher停在被压缩的版本，只知道a和c，需要重新list补发b的Add以及a、c的Remove
+	known := map[string]discovery.Peer{
+		DefaultPrefix + "a": {Addr: "127.0.0.1:8001"},
+		DefaultPrefix + "c": {Addr: "127.0.0.1:8003"},
+	}
+	events := make(chan discovery.Event)
+	go NewDiscovery(client, 100*time.Millisecond, 2*time.Second).watch(ctx, rev+1, known, events)
+
+	expect := []string{
+		"add " + DefaultPrefix + "b 127.0.0.1:8002",
+		"remove " + DefaultPrefix + "a 127.0.0.1:8001",
+		"remove " + DefaultPrefix + "c 127.0.0.1:8003",
+		"add " + DefaultPrefix + "d 127.0.0.1:8004",
+	}
+	for i, want := range expect {
+		if i == len(expect)-1 {
+			//todo 补发之后从新的版本继续监听
+			put("d", "127.0.0.1:8004")
+		}
+		select {
+		case event := <-events:
+			if got := fmt.Sprintf("%s %s %s", event.Type, event.Key, event.Peer.Addr); got != want {
+				t.Fatalf("got event %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+// canceledWatcher 第一次Watch返回一个被取消的响应，之后的Watch一直阻塞，记录每次Watch的版本
+type canceledWatcher struct {
+	clientv3.Watcher
+	calls atomic.Int64
+	revs  chan int64
+}
+
+func (w *canceledWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	w.revs <- op.Rev()
+	ch := make(chan clientv3.WatchResponse, 1)
+	if w.calls.Add(1) == 1 {
+		resp := clientv3.WatchResponse{Canceled: true}
+		resp.Header.Revision = 100
+		ch <- resp
+		close(ch)
+		return ch
+	}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func TestWatchCanceled(t *testing.T) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	watcher := &canceledWatcher{revs: make(chan int64, 2)}
+	d := NewDiscovery(&clientv3.Client{Watcher: watcher}, 10*time.Millisecond, 2*time.Second)
+	events := make(chan discovery.Event)
+	go d.watch(ctx, 5, map[string]discovery.Peer{}, events)
+
+	//todo 被取消的响应不能推进版本，重新监听时仍然从5开始
+	for i := 0; i < 2; i++ {
+		select {
+		case rev := <-watcher.revs:
+			if rev != 5 {
+				t.Fatalf("watch %d should start from revision 5, got %d", i, rev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for watch")
+		}
+	}
+}
+
+func TestWeight(t *testing.T) {
+	client := startEtcd(t)
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	//todo 旧版本的结点直接把地址作为值
+	if _, err := client.Put(ctx, DefaultPrefix+"0legacy", "127.0.0.1:8000"); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDiscovery(client, 100*time.Millisecond, 2*time.Second)
+	if err := d.Register(ctx, discovery.Peer{Addr: "127.0.0.1:8001", Weight: 2}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := NewDiscovery(client, 100*time.Millisecond, 2*time.Second).Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SetWeight(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []discovery.Peer{
+		{Addr: "127.0.0.1:8000"},
+		{Addr: "127.0.0.1:8001", Weight: 2},
+		{Addr: "127.0.0.1:8001", Weight: 3},
+	}
+	for _, want := range expect {
+		select {
+		case event := <-events:
+			if event.Type != discovery.Add || event.Peer != want {
+				t.Fatalf("got event %s %v, want add %v", event.Type, event.Peer, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", want)
+		}
+	}
+}
@@ -1,8 +1,9 @@
 package etcd
 
 import (
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"log"
+	"log/slog"
 	"time"
 )
 
@@ -20,27 +21,37 @@ type ConfigEtcd struct {
 	EndPoints   []string
 	DialTimeout time.Duration
 	WatcherTime time.Duration
+	// TTL 注册时租约的过期时间，结点崩溃后最多TTL时间就会从其他结点的哈希环中移除
+	TTL time.Duration
+	// Logger Discovery以及InitDiscovery使用的日志，为nil时不输出日志
+	Logger *slog.Logger
 }
 
-func (e *ConfigEtcd) InitDiscovery(endPoints []string, dialTimeout time.Duration) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("[etcd init] panic")
-		}
-	}()
-
+// NewClient 根据配置创建一个新的etcd client，EndPoints和DialTimeout为空时使用默认值，client由调用方关闭
+func (e *ConfigEtcd) NewClient() (*clientv3.Client, error) {
+	endPoints, dialTimeout := e.EndPoints, e.DialTimeout
 	if dialTimeout == 0 {
 		dialTimeout = defaultDialTimeout
 	}
-
 	if endPoints == nil {
 		endPoints = defaultEndPoints
 	}
-
-	Client, err = clientv3.New(clientv3.Config{
+	return clientv3.New(clientv3.Config{
 		Endpoints:   endPoints,
 		DialTimeout: dialTimeout,
 	})
+}
+
+// InitDiscovery 创建全局的Client，同一个进程中只能连接一个etcd集群，新代码应该使用NewClient
+func (e *ConfigEtcd) InitDiscovery(endPoints []string, dialTimeout time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.OrDiscard(e.Logger).Warn("etcd init failed", "err", r)
+		}
+	}()
+
+	config := ConfigEtcd{EndPoints: endPoints, DialTimeout: dialTimeout}
+	Client, err = config.NewClient()
 	if err != nil {
 		panic(err)
 	}
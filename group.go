@@ -1,21 +1,81 @@
 package simpleCache
 
 import (
+	"context"
 	"errors"
 	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
 	"github.com/thewisecirno/simple_distributed_cache/singleFlight"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"math/rand"
 	"sync"
+	"time"
 )
 
+// ErrNotFound Getter在key不存在时应该返回ErrNotFound(或者包装了它的错误)，
+// Group会把key缓存为一条negative记录，negativeTTL内同一个key不会再访问数据库，防止缓存穿透
+var ErrNotFound = errors.New("key not found")
+
 type Group struct {
 	name      string
 	getter    Getter
-	mainCache cache
-	peers     PeerPicker
-	single    *singleFlight.Group
+	mainCache *shardedCache
+	//hotCache保存从其他peer获取到的热点数据副本，避免热点key的请求全部打到owner结点
+	hotCache *shardedCache
+	//negativeCache保存已知不存在的key，与mainCache分开计算容量，大量不存在的key不会挤掉正常的记录
+	negativeCache *shardedCache
+	peers         PeerPicker
+	single        *singleFlight.Group
+	//默认过期时间，0表示永不过期
+	ttl time.Duration
+
+	//每从peer获取hotOdds次，放入hotCache一次，0表示关闭hotCache
+	hotOdds int
+	//每个key的owner数量，首选owner失败时依次尝试其他owner
+	replicas int
+	//negative记录的过期时间，0表示不缓存不存在的key
+	negativeTTL time.Duration
+	//共享的加载使用的超时时间
+	loadTimeout time.Duration
+	logger      *slog.Logger
+	//缓存命中等热点路径的日志采样
+	logSampler sampler
+	//可选的Bloom filter，load之前过滤一定不存在的key
+	bloom *bloomGuard
+	//剩余有效期不足TTL的refreshAhead时在后台刷新，0表示关闭
+	refreshAhead float64
+	//过期后继续返回旧值并在后台刷新的时间
+	staleWhileRevalidate time.Duration
+	//过期后加载失败时返回旧值的时间
+	staleOnError time.Duration
+
+	Stats Stats
+
+	//Close时关闭，通知janitor和Bloom filter重建等后台goroutine退出
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	//以下字段只在NewGroup创建mainCache时使用
+	policy  PolicyType
+	shards  int
+	janitor time.Duration
 }
 
+const (
+	//hotCache占cacheBytes的1/defaultHotCacheFraction
+	defaultHotCacheFraction = 8
+	defaultHotCacheOdds     = 10
+	//negativeCache占cacheBytes的1/defaultNegativeCacheFraction
+	defaultNegativeCacheFraction = 16
+	defaultNegativeTTL           = time.Second * 5
+	//一次共享的加载(包括转发给peer)最长的时间
+	defaultLoadTimeout = time.Second * 10
+	//Set和Remove之后通知其他peer删除hotCache副本的超时时间
+	defaultInvalidateTimeout = time.Second
+)
+
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
@@ -26,28 +86,172 @@ func (f GetterHandler) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
-var (
-	mu     sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// ContextGetter 可以感知ctx的取消和超时，慢查询可以被及时中断
+type ContextGetter interface {
+	Getter
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+type ContextGetterHandler func(ctx context.Context, key string) ([]byte, error)
+
+func (f ContextGetterHandler) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f ContextGetterHandler) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// TTLGetter 可以为每个key单独指定过期时间，ttl为0时使用Group的默认过期时间
+type TTLGetter interface {
+	Getter
+	GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+type TTLGetterHandler func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+func (f TTLGetterHandler) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
+func (f TTLGetterHandler) GetWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
+// GroupOption 用于NewGroup的可选配置
+type GroupOption func(*Group)
+
+// WithTTL 设置Group的默认过期时间，过期后的记录会重新从getter加载
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithJanitor 开启后台清理，每隔interval删除一次过期的记录
+func WithJanitor(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.janitor = interval
+	}
+}
+
+// WithHotCache 每从peer获取odds次就在本地hotCache中保存一份副本，odds为0时关闭hotCache
+func WithHotCache(odds int) GroupOption {
+	return func(g *Group) {
+		g.hotOdds = odds
+	}
+}
+
+// WithShards 设置mainCache的分片数，默认为defaultShards，容量较小时减少到每个分片至少有minShardBytes(1MB)
+// 每个分片只有cacheBytes/shards的容量，超过这个大小的值不会被缓存，需要保证分片的容量远大于单个值
+func WithShards(shards int) GroupOption {
+	return func(g *Group) {
+		g.shards = shards
+	}
+}
+
+// WithReplicas 每个key有n个owner(首选owner以及一致性哈希上的后n-1个结点)，从首选owner获取失败时依次尝试其他owner，
+// 而不是直接调用本地的getter，一个结点宕机时只有它的下一个owner会访问数据库。peers需要实现ReplicaPicker
+// 收到failover请求的owner直接在本地加载，不会再尝试已经失败的首选owner
+func WithReplicas(n int) GroupOption {
+	return func(g *Group) {
+		g.replicas = n
+	}
+}
 
-func NewGroup(groupName string, cacheBytes int64, getter Getter) *Group {
+// WithNegativeTTL 设置不存在的key(Getter返回ErrNotFound)的缓存时间，应该比正常记录短很多，0表示不缓存
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithLoadTimeout 设置调用者等待一次加载的最长时间，默认为defaultLoadTimeout
+// 同一个key的加载被所有调用者共享，传给getter和peer的deadline是还在等待的调用者中最晚的deadline，所有调用者都放弃后加载被取消
+func WithLoadTimeout(timeout time.Duration) GroupOption {
+	if timeout <= 0 {
+		panic("load timeout should be positive")
+	}
+	return func(g *Group) {
+		g.loadTimeout = timeout
+	}
+}
+
+// WithLogger 设置Group的日志，默认不输出日志，日志中会带上group字段
+func WithLogger(logger *slog.Logger) GroupOption {
+	return func(g *Group) {
+		g.logger = logger
+	}
+}
+
+// WithLogSampling 缓存命中、加载成功等Debug日志每every次只输出一次，默认为defaultLogSampling，1表示全部输出
+func WithLogSampling(every int) GroupOption {
+	return func(g *Group) {
+		g.logSampler.every = uint64(every)
+	}
+}
+
+// NewGroup 在defaultNode中创建Group
+func NewGroup(groupName string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
+	return defaultNode.NewGroup(groupName, cacheBytes, getter, opts...)
+}
+
+func newGroup(groupName string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil getter")
 	}
-	mu.Lock()
-	defer mu.Unlock()
 	group := &Group{
-		name:      groupName,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		single:    &singleFlight.Group{},
+		name:        groupName,
+		getter:      getter,
+		single:      &singleFlight.Group{},
+		hotOdds:     defaultHotCacheOdds,
+		negativeTTL: defaultNegativeTTL,
+		loadTimeout: defaultLoadTimeout,
+		closed:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(group)
+	}
+	group.logger = logging.OrDiscard(group.logger).With("group", groupName)
+	//todo hotCache的容量从cacheBytes中划出，总容量不变
+	var hotBytes int64
+	if group.hotOdds > 0 {
+		hotBytes = cacheBytes / defaultHotCacheFraction
+		//todo 同negativeCache，容量为0会变成不限制容量，直接关闭hotCache
+		if cacheBytes > 0 && hotBytes == 0 {
+			group.hotOdds = 0
+		}
+	}
+	var negativeBytes int64
+	if group.negativeTTL > 0 {
+		negativeBytes = cacheBytes / defaultNegativeCacheFraction
+		//todo cacheBytes太小时划出的容量为0，而0表示不限制容量，这时直接关闭negativeCache
+		if cacheBytes > 0 && negativeBytes == 0 {
+			group.negativeTTL = 0
+		}
+	}
+	group.mainCache = newShardedCache(cacheBytes-hotBytes-negativeBytes, group.shards, group.policy)
+	group.hotCache = newShardedCache(hotBytes, 0, LRU)
+	group.negativeCache = newShardedCache(negativeBytes, 0, LRU)
+	if group.janitor > 0 {
+		group.mainCache.startJanitor(group.janitor, group.closed)
+		group.hotCache.startJanitor(group.janitor, group.closed)
+		group.negativeCache.startJanitor(group.janitor, group.closed)
+	}
+	if group.bloom != nil {
+		group.bloom.start(group.logger, group.closed)
 	}
-	groups[groupName] = group
-	groups[groupName].RegisterPeers(Pool)
 	return group
 }
 
+// Close 停止g的后台goroutine，之后g仍然可以使用，只是不再清理过期记录和重建Bloom filter，可以重复调用
+func (g *Group) Close() {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+	})
+}
+
 func (g *Group) RegisterPeers(peer PeerPicker) {
 	if g.peers != nil {
 		panic("RegisterPeerPicker called more than once")
@@ -55,41 +259,211 @@ func (g *Group) RegisterPeers(peer PeerPicker) {
 	g.peers = peer
 }
 
+// GetGroup 返回defaultNode中的Group
 func GetGroup(groupName string) *Group {
-	mu.RLock()
-	defer mu.RUnlock()
-	if group, ok := groups[groupName]; ok {
-		return group
-	}
-	return nil
+	return defaultNode.GetGroup(groupName)
 }
 
 func (g *Group) Get(key string) (byteView *ByteView, err error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与Get相同，ctx的取消和超时会传递给getter和peer
+func (g *Group) GetContext(ctx context.Context, key string) (byteView *ByteView, err error) {
 	if key == "" {
 		return &ByteView{}, errors.New("key is required")
 	}
+	g.Stats.Gets.Add(1)
+	ctx, span := startSpan(ctx, "Group.Get")
+	setKeyAttributes(span, g.name, key)
+	defer func() {
+		endSpan(span, err)
+	}()
+	view, stale, done, err := g.lookup(ctx, key, span)
+	if done {
+		return view, err
+	}
+	view, err = g.load(ctx, key)
+	g.bloomLoaded(key, err)
+	if err != nil && g.serveStale(key, stale, err) {
+		return stale, nil
+	}
+	return view, err
+}
+
+// lookup 依次检查mainCache、hotCache和negativeCache，done为true时直接返回view和err，
+// 否则需要加载，stale是mainCache中过期但还没有被删除的记录，加载失败时可能返回它
+// 命中了哪一级缓存记录在调用方的span中，不单独创建span，span为nil时不记录
+func (g *Group) lookup(ctx context.Context, key string, span trace.Span) (view, stale *ByteView, done bool, err error) {
+	result := "miss"
+	if span != nil && span.IsRecording() {
+		defer func() {
+			span.SetAttributes(attribute.String("cache.result", result))
+		}()
+	}
 	if view, ok1 := g.mainCache.get(key); ok1 {
-		log.Println("cache hit")
-		log.Printf("[now cache] %#v", g.mainCache.cache)
-		return view, nil
+		if g.serveCached(key, view) {
+			result = "hit"
+			if view.expired(time.Now()) {
+				result = "stale"
+			}
+			if sampled(ctx, g.logger, &g.logSampler) {
+				g.logger.Debug("cache hit", keyHash(key), "result", result, "entries", g.mainCache.len())
+			}
+			return view, nil, true, nil
+		}
+		stale = view
+	}
+	if view, ok := g.hotCache.get(key); ok {
+		result = "hot_hit"
+		g.Stats.HotCacheHits.Add(1)
+		if sampled(ctx, g.logger, &g.logSampler) {
+			g.logger.Debug("hot cache hit", keyHash(key))
+		}
+		return view, nil, true, nil
 	}
-	return g.load(key)
+	if _, ok := g.negativeCache.get(key); ok {
+		result = "negative_hit"
+		g.Stats.NegativeHits.Add(1)
+		return &ByteView{}, nil, true, ErrNotFound
+	}
+	return nil, stale, false, nil
 }
 
-func (g *Group) load(key string) (byteView *ByteView, err error) {
-	bytes, err := g.single.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if bytes, err1 := g.getFormPeer(peer, key); err1 == nil {
-					log.Println("[get from peer]", bytes.String())
-					return bytes, err1
-				}
+// Set 将key对应的值写入owner结点的缓存中，数据源发生变化时用于主动推送新值
+func (g *Group) Set(ctx context.Context, key string, value []byte) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	//todo 每个结点的Bloom filter都需要知道新的key，owner的过滤器由setLocally更新
+	if g.bloom != nil {
+		g.bloom.add(key)
+	}
+	var owner PeerGetter
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			err := peer.Set(ctx, &pb.SetRequest{
+				Group: g.name,
+				Key:   key,
+				Value: value,
+			})
+			if err != nil {
+				return err
 			}
-		} else {
-			log.Println("[g.peers is nil]")
+			owner = peer
 		}
-		return g.getLocally(key)
+	}
+	if owner == nil {
+		g.setLocally(key, value, 0)
+	} else {
+		g.Invalidate(key)
+	}
+	g.invalidatePeers(ctx, key, owner)
+	return nil
+}
+
+// Remove 将key从owner结点的缓存中删除
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return errors.New("key is required")
+	}
+	var owner PeerGetter
+	if g.peers != nil {
+		if peer, ok := g.pickOwner(key); ok {
+			err := peer.Remove(ctx, &pb.Request{
+				Group: g.name,
+				Key:   key,
+			})
+			if err != nil {
+				return err
+			}
+			owner = peer
+		}
+	}
+	g.Invalidate(key)
+	g.invalidatePeers(ctx, key, owner)
+	return nil
+}
+
+// Invalidate 只删除本结点缓存中的key(包括hotCache中的副本)，不会通知其他结点
+func (g *Group) Invalidate(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.negativeCache.remove(key)
+}
+
+// invalidatePeers 并行通知除owner以外的所有peer删除hotCache中的副本，peers需要实现PeerLister
+// owner的写入已经成功，通知是尽力而为的：使用自己的超时，失败只记录日志和Stats.InvalidateErrors，不返回给调用方
+func (g *Group) invalidatePeers(ctx context.Context, key string, owner PeerGetter) {
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.WithoutCancel(ctx), defaultInvalidateTimeout)
+	defer cancelFunc()
+	var wg sync.WaitGroup
+	for _, peer := range lister.GetAll() {
+		if peer == owner {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := peer.Remove(ctx, &pb.Request{Group: g.name, Key: key}); err != nil {
+				g.Stats.InvalidateErrors.Add(1)
+				g.logger.Warn("invalidate hot copy failed", keyHash(key), peerAttr(peer), "err", err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// setLocally expire为unix nano，0表示使用Group的默认过期时间
+func (g *Group) setLocally(key string, value []byte, expire int64) {
+	if g.bloom != nil {
+		g.bloom.add(key)
+	}
+	view := &ByteView{byteView: cloneByte(value), expire: g.expireAt(0)}
+	if expire != 0 {
+		view.expire = time.Unix(0, expire)
+	}
+	g.populateCache(key, view)
+}
+
+// expireAt 计算过期时间，ttl为0时使用Group的默认过期时间
+func (g *Group) expireAt(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// forwardFlight 会转发给peer的加载使用的singleFlight key前缀
+const forwardFlight = "\x00forward\x00"
+
+func (g *Group) load(ctx context.Context, key string) (byteView *ByteView, err error) {
+	//todo 每个调用者最多等待loadTimeout，fn的ctx由所有等待者共同决定，见singleFlight.DoWithContext
+	ctx, cancelFunc := context.WithTimeout(ctx, g.loadTimeout)
+	defer cancelFunc()
+	flight, peers := g.flight(ctx, key)
+	ctx, span := startSpan(ctx, "singleFlight.Do")
+	bytes, err, shared := g.single.DoWithContext(ctx, flight, func(ctx context.Context) (interface{}, error) {
+		return g.fetch(ctx, key, peers)
 	})
+	//todo 等到的是GetMulti转发的结果并且所有owner都失败了，与GetMulti一样改为在本地加载
+	if errors.Is(err, errOwnersFailed) {
+		bytes, err, shared = g.single.DoWithContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+			return g.getLocally(ctx, key)
+		})
+	}
+	if shared {
+		g.Stats.LoadsDeduped.Add(1)
+	}
+	span.SetAttributes(attribute.Bool("singleflight.deduped", shared))
+	endSpan(span, err)
 
 	if err == nil {
 		return bytes.(*ByteView), nil
@@ -97,12 +471,89 @@ func (g *Group) load(key string) (byteView *ByteView, err error) {
 	return
 }
 
-func (g *Group) getLocally(key string) (*ByteView, error) {
-	get, err := g.getter.Get(key)
+// refreshContext 后台刷新使用的ctx，没有等待的调用者，只受loadTimeout限制
+func (g *Group) refreshContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), g.loadTimeout)
+}
+
+// flight 返回需要尝试的peer以及使用的singleFlight key。会转发出去的加载和本地加载使用不同的key，
+// 两个结点对owner的看法不一致时，转发出去的请求不会与对方转发回来的请求互相等待
+func (g *Group) flight(ctx context.Context, key string) (string, []PeerGetter) {
+	if g.peers == nil || isFromPeer(ctx) {
+		return key, nil
+	}
+	peers := g.pickPeers(key)
+	if len(peers) == 0 {
+		return key, nil
+	}
+	return forwardFlight + key, peers
+}
+
+// fetch 依次尝试peers，都失败或者peers为空时调用本地getter
+func (g *Group) fetch(ctx context.Context, key string, peers []PeerGetter) (interface{}, error) {
+	for i, peer := range peers {
+		start := time.Now()
+		bytes, err1 := g.getFormPeer(ctx, peer, key)
+		if err1 == nil {
+			g.Stats.PeerLoads.Add(1)
+			if i > 0 {
+				g.Stats.ReplicaLoads.Add(1)
+			}
+			if sampled(ctx, g.logger, &g.logSampler) {
+				g.logger.Debug("loaded from peer", keyHash(key), peerAttr(peer), "latency", time.Since(start))
+			}
+			return bytes, err1
+		}
+		//todo owner已经确认key不存在，不需要再尝试其他owner或者访问数据库
+		if errors.Is(err1, ErrNotFound) {
+			g.Stats.PeerLoads.Add(1)
+			g.populateNegative(key)
+			return nil, err1
+		}
+		g.Stats.PeerErrors.Add(1)
+		g.logger.Warn("load from peer failed, try next owner", keyHash(key), peerAttr(peer),
+			"attempt", i, "latency", time.Since(start), "err", err1)
+	}
+	return g.getLocally(ctx, key)
+}
+
+func (g *Group) getLocally(ctx context.Context, key string) (*ByteView, error) {
+	rejected, checked := g.bloomRejects(key)
+	if rejected {
+		return &ByteView{}, ErrNotFound
+	}
+	g.Stats.LocalLoads.Add(1)
+	var (
+		get []byte
+		ttl time.Duration
+		err error
+	)
+	ctx, span := startSpan(ctx, "Getter.Get")
+	start := time.Now()
+	defer func() {
+		endSpan(span, err)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			g.logger.Warn("getter failed", keyHash(key), "latency", time.Since(start), "err", err)
+		} else if sampled(ctx, g.logger, &g.logSampler) {
+			g.logger.Debug("loaded from getter", keyHash(key), "latency", time.Since(start), "found", err == nil)
+		}
+	}()
+	switch getter := g.getter.(type) {
+	case TTLGetter:
+		get, ttl, err = getter.GetWithTTL(ctx, key)
+	case ContextGetter:
+		get, err = getter.GetContext(ctx, key)
+	default:
+		get, err = g.getter.Get(key)
+	}
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegative(key)
+			g.bloomFalsePositive(checked)
+		}
 		return &ByteView{}, err
 	}
-	value := &ByteView{byteView: cloneByte(get)}
+	value := &ByteView{byteView: cloneByte(get), expire: g.expireAt(ttl)}
 	g.populateCache(key, value)
 	return value, nil
 }
@@ -110,10 +561,41 @@ func (g *Group) getLocally(key string) (*ByteView, error) {
 func (g *Group) populateCache(key string, val *ByteView) {
 	//g.mainCache.mu.Lock()
 	//defer g.mainCache.mu.Unlock()
+	g.prepare(val)
 	g.mainCache.add(key, val)
+	g.negativeCache.remove(key)
+}
+
+// populateNegative 记录key不存在，只占用key本身的字节数
+func (g *Group) populateNegative(key string) {
+	//todo 过期后保留的旧值也不能再返回
+	g.mainCache.remove(key)
+	if g.negativeTTL <= 0 {
+		return
+	}
+	g.negativeCache.add(key, &ByteView{expire: time.Now().Add(g.negativeTTL)})
+}
+
+// pickPeers 按优先级返回需要尝试的peer，为空时由自己加载
+func (g *Group) pickPeers(key string) []PeerGetter {
+	if picker, ok := g.peers.(ReplicaPicker); ok && g.replicas > 1 {
+		return picker.PickReplicas(key, g.replicas)
+	}
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []PeerGetter{peer}
+	}
+	return nil
+}
+
+// pickOwner 返回写入key时使用的owner，peers没有实现OwnerPicker时使用PickPeer
+func (g *Group) pickOwner(key string) (PeerGetter, bool) {
+	if picker, ok := g.peers.(OwnerPicker); ok {
+		return picker.PickOwner(key)
+	}
+	return g.peers.PickPeer(key)
 }
 
-func (g *Group) getFormPeer(peerGetter PeerGetter, key string) (*ByteView, error) {
+func (g *Group) getFormPeer(ctx context.Context, peerGetter PeerGetter, key string) (*ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
@@ -121,10 +603,23 @@ func (g *Group) getFormPeer(peerGetter PeerGetter, key string) (*ByteView, error
 	res := &pb.Response{
 		Value: nil,
 	}
-	err := peerGetter.Get(req, res)
+	err := peerGetter.Get(ctx, req, res)
 	if err != nil {
 		return &ByteView{}, err
 	}
 
-	return &ByteView{byteView: res.Value}, nil
+	return g.peerView(key, res.Value, res.Expire), nil
+}
+
+// peerView 把从peer获取到的值转换成ByteView，expire为unix nano
+func (g *Group) peerView(key string, value []byte, expire int64) *ByteView {
+	view := &ByteView{byteView: value}
+	if expire != 0 {
+		view.expire = time.Unix(0, expire)
+	}
+	//todo 按概率在本地保存一份副本，越热的key越容易被复制到hotCache中
+	if g.hotOdds > 0 && rand.Intn(g.hotOdds) == 0 {
+		g.hotCache.add(key, view)
+	}
+	return view
 }
@@ -1,9 +1,17 @@
 package simpleCache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
 	"log"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var db = map[string]string{
@@ -40,3 +48,513 @@ func TestGet(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestSetRemove(t *testing.T) {
+	loadCounts := make(map[string]int, len(db))
+	gee := NewGroup("set-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			loadCounts[key] += 1
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	ctx := context.Background()
+	if err := gee.Set(ctx, "Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "700" || loadCounts["Tom"] != 0 {
+		t.Fatalf("Set should update cache without loading, got %v %v", view, err)
+	}
+
+	if err := gee.Remove(ctx, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != db["Tom"] || loadCounts["Tom"] != 1 {
+		t.Fatalf("Remove should evict Tom and reload it from getter, got %v %v", view, err)
+	}
+
+	gee.Invalidate("Tom")
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("Invalidate should evict Tom from mainCache")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	loadCounts := make(map[string]int, len(db))
+	gee := NewGroup("ttl-scores", 2<<10, TTLGetterHandler(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			loadCounts[key] += 1
+			if key == "Jack" {
+				return []byte(db[key]), time.Hour, nil
+			}
+			if v, ok := db[key]; ok {
+				return []byte(v), 0, nil
+			}
+			return nil, 0, fmt.Errorf("%s not exist", key)
+		}), WithTTL(50*time.Millisecond))
+
+	for _, k := range []string{"Tom", "Jack"} {
+		if view, err := gee.Get(k); err != nil || view.String() != db[k] {
+			t.Fatalf("failed to get value of %s", k)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, k := range []string{"Tom", "Jack"} {
+		if view, err := gee.Get(k); err != nil || view.String() != db[k] {
+			t.Fatalf("failed to get value of %s", k)
+		}
+	}
+	if loadCounts["Tom"] != 2 {
+		t.Fatalf("Tom should expire with the default ttl and be loaded twice, got %d", loadCounts["Tom"])
+	}
+	if loadCounts["Jack"] != 1 {
+		t.Fatalf("Jack has its own ttl and should be loaded once, got %d", loadCounts["Jack"])
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	gee := NewGroup("janitor-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithTTL(10*time.Millisecond), WithJanitor(10*time.Millisecond))
+
+	for k := range db {
+		if _, err := gee.Get(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := gee.mainCache.len(); n != 0 {
+		t.Fatalf("janitor should remove all expired entries, %d left", n)
+	}
+
+	//todo Close之后janitor退出，过期的记录不再被清理
+	gee.Close()
+	gee.Close()
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := gee.mainCache.len(); n != 1 {
+		t.Fatalf("janitor should be stopped after Close, %d entries left", n)
+	}
+}
+
+func TestLargeValue(t *testing.T) {
+	//todo 默认不分片，接近cacheBytes的值也能被缓存
+	value := strings.Repeat("x", 5<<10)
+	var loads atomic.Int64
+	gee := NewGroup("large-scores", 64<<10, GetterHandler(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte(value), nil
+	}))
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("large"); err != nil || view.Len() != len(value) {
+			t.Fatalf("large value should be loaded, got %v", err)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("large value should be cached, loaded %d times", loads.Load())
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	gee := NewGroup("context-scores", 2<<10, ContextGetterHandler(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if key == "slow" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return []byte(db[key]), nil
+		}), WithLoadTimeout(100*time.Millisecond))
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFunc()
+	if _, err := gee.GetContext(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow key should time out, got %v", err)
+	}
+	if view, err := gee.GetContext(context.Background(), "Tom"); err != nil || view.String() != db["Tom"] {
+		t.Fatalf("failed to get value of Tom: %v", err)
+	}
+	//todo 调用者没有超时，加载受loadTimeout限制
+	if _, err := gee.GetContext(context.Background(), "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow key should hit the load timeout, got %v", err)
+	}
+}
+
+func TestGetContextShared(t *testing.T) {
+	gee := NewGroup("context-shared-scores", 2<<10, ContextGetterHandler(
+		func(ctx context.Context, key string) ([]byte, error) {
+			select {
+			case <-time.After(50 * time.Millisecond):
+				return []byte(db[key]), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}))
+
+	//todo 第一个调用者超时放弃，不能取消其他调用者共享的加载
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFunc()
+	errs := make(chan error, 1)
+	go func() {
+		_, err := gee.GetContext(ctx, "Tom")
+		errs <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	if view, err := gee.GetContext(context.Background(), "Tom"); err != nil || view.String() != db["Tom"] {
+		t.Fatalf("second caller should get Tom, got %v %v", view, err)
+	}
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("first caller should give up, got %v", err)
+	}
+	if gee.Stats.LocalLoads.Load() != 1 {
+		t.Fatalf("Tom should be loaded once, got %d", gee.Stats.LocalLoads.Load())
+	}
+}
+
+type fakePeer struct {
+	mu      sync.Mutex
+	gets    int
+	removes int
+	//不为nil时Remove返回它
+	removeErr error
+}
+
+func (f *fakePeer) Get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	res.Value = []byte("peer-" + req.GetKey())
+	return nil
+}
+
+func (f *fakePeer) Set(ctx context.Context, req *pb.SetRequest) error {
+	return nil
+}
+
+func (f *fakePeer) Remove(ctx context.Context, req *pb.Request) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removes++
+	return f.removeErr
+}
+
+// fakePicker 所有key都属于owner
+type fakePicker struct {
+	owner  *fakePeer
+	others []*fakePeer
+}
+
+func (f *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	return f.owner, true
+}
+
+func (f *fakePicker) GetAll() []PeerGetter {
+	getters := []PeerGetter{f.owner}
+	for _, other := range f.others {
+		getters = append(getters, other)
+	}
+	return getters
+}
+
+func TestHotCache(t *testing.T) {
+	gee := NewGroup("hot-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be loaded from peer", key)
+		}), WithHotCache(1))
+	picker := &fakePicker{owner: &fakePeer{}, others: []*fakePeer{{}, {}}}
+	gee.RegisterPeers(picker)
+
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "peer-Tom" {
+			t.Fatalf("failed to get Tom from peer: %v", err)
+		}
+	}
+	if picker.owner.gets != 1 {
+		t.Fatalf("Tom should be fetched from owner once, got %d", picker.owner.gets)
+	}
+	if gee.Stats.HotCacheHits.Load() != 2 || gee.Stats.CacheHits.Load() != 0 || gee.Stats.PeerLoads.Load() != 1 {
+		t.Fatalf("unexpected stats: hot hits %d, main hits %d, peer loads %d",
+			gee.Stats.HotCacheHits.Load(), gee.Stats.CacheHits.Load(), gee.Stats.PeerLoads.Load())
+	}
+
+	if err := gee.Remove(context.Background(), "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if picker.owner.removes != 1 {
+		t.Fatalf("owner should receive one Remove, got %d", picker.owner.removes)
+	}
+	for _, other := range picker.others {
+		if other.removes != 1 {
+			t.Fatalf("every other peer should be asked to drop its hot copy, got %d", other.removes)
+		}
+	}
+	if _, ok := gee.hotCache.get("Tom"); ok {
+		t.Fatal("Remove should evict Tom from hotCache")
+	}
+}
+
+func TestInvalidatePeersBestEffort(t *testing.T) {
+	gee := NewGroup("invalidate-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	down := &fakePeer{removeErr: errors.New("peer down")}
+	picker := &fakePicker{owner: &fakePeer{}, others: []*fakePeer{down, {}}}
+	gee.RegisterPeers(picker)
+
+	//todo owner的写入已经成功，其他peer删除副本失败不影响Set和Remove的结果
+	if err := gee.Set(context.Background(), "Tom", []byte("630")); err != nil {
+		t.Fatalf("Set should succeed when only invalidation fails, got %v", err)
+	}
+	if err := gee.Remove(context.Background(), "Tom"); err != nil {
+		t.Fatalf("Remove should succeed when only invalidation fails, got %v", err)
+	}
+	if gee.Stats.InvalidateErrors.Load() != 2 || picker.others[1].removes != 2 {
+		t.Fatalf("invalidate errors %d, removes of the live peer %d", gee.Stats.InvalidateErrors.Load(), picker.others[1].removes)
+	}
+
+	//todo 没有实现PeerLister的picker不通知其他peer
+	gee2 := NewGroup("invalidate-no-lister", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	owner := &fakePeer{}
+	gee2.RegisterPeers(ownerOnlyPicker{owner: owner})
+	if err := gee2.Remove(context.Background(), "Tom"); err != nil || owner.removes != 1 {
+		t.Fatalf("Remove should only reach the owner, err %v, removes %d", err, owner.removes)
+	}
+}
+
+// ownerOnlyPicker 只实现PeerPicker
+type ownerOnlyPicker struct {
+	owner *fakePeer
+}
+
+func (p ownerOnlyPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.owner, true
+}
+
+func TestHotCacheTinyBudget(t *testing.T) {
+	//todo cacheBytes/8为0，不能变成不限制容量的hotCache
+	gee := NewGroup("tiny-hot-scores", 7, GetterHandler(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be loaded from peer", key)
+		}), WithHotCache(1))
+	gee.RegisterPeers(&fakePicker{owner: &fakePeer{}})
+	for i := 0; i < 100; i++ {
+		if _, err := gee.Get(fmt.Sprintf("key%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gee.hotOdds != 0 || gee.hotCache.len() != 0 {
+		t.Fatalf("hotCache should be disabled, odds %d, %d entries", gee.hotOdds, gee.hotCache.len())
+	}
+}
+
+func TestHotCacheDisabled(t *testing.T) {
+	gee := NewGroup("no-hot-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s should be loaded from peer", key)
+		}), WithHotCache(0))
+	picker := &fakePicker{owner: &fakePeer{}}
+	gee.RegisterPeers(picker)
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("Tom"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if picker.owner.gets != 3 {
+		t.Fatalf("without hotCache every Get should reach the owner, got %d", picker.owner.gets)
+	}
+}
+
+type deadPeer struct {
+	fakePeer
+}
+
+func (d *deadPeer) Get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gets++
+	return errors.New("connection refused")
+}
+
+// replicaPicker 所有key的owner依次为replicas中的peer
+type replicaPicker struct {
+	fakePicker
+	replicas []PeerGetter
+}
+
+func (r *replicaPicker) PickReplicas(key string, n int) []PeerGetter {
+	if n > len(r.replicas) {
+		n = len(r.replicas)
+	}
+	return r.replicas[:n]
+}
+
+func TestReplicaFailover(t *testing.T) {
+	var localLoads atomic.Int64
+	gee := NewGroup("replica-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			localLoads.Add(1)
+			return []byte("local-" + key), nil
+		}), WithReplicas(3), WithHotCache(0))
+	dead, live := &deadPeer{}, &fakePeer{}
+	picker := &replicaPicker{fakePicker: fakePicker{owner: &dead.fakePeer}, replicas: []PeerGetter{dead, live}}
+	gee.RegisterPeers(picker)
+
+	//todo 首选owner宕机时从下一个owner获取，而不是访问数据库
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "peer-Tom" {
+		t.Fatalf("Tom should be fetched from the second owner: %v %v", view, err)
+	}
+	if dead.gets != 1 || live.gets != 1 || localLoads.Load() != 0 {
+		t.Fatalf("unexpected gets: dead %d, live %d, local %d", dead.gets, live.gets, localLoads.Load())
+	}
+	if gee.Stats.ReplicaLoads.Load() != 1 || gee.Stats.PeerErrors.Load() != 1 {
+		t.Fatalf("unexpected stats: replica loads %d, peer errors %d", gee.Stats.ReplicaLoads.Load(), gee.Stats.PeerErrors.Load())
+	}
+
+	//todo 所有owner都失败时才由自己加载
+	picker.replicas = []PeerGetter{dead}
+	if view, err := gee.Get("Sam"); err != nil || view.String() != "local-Sam" {
+		t.Fatalf("Sam should be loaded locally: %v %v", view, err)
+	}
+}
+
+func TestReplicaFailoverFromPeer(t *testing.T) {
+	var localLoads atomic.Int64
+	gee := NewGroup("replica-from-peer", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			localLoads.Add(1)
+			return []byte("local-" + key), nil
+		}), WithReplicas(3), WithHotCache(0))
+	dead := &deadPeer{}
+	gee.RegisterPeers(&replicaPicker{fakePicker: fakePicker{owner: &dead.fakePeer}, replicas: []PeerGetter{dead}})
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	getter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+
+	//todo 副本收到failover请求时首选owner已经失败过一次，直接在本地加载，不再访问它
+	res := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "replica-from-peer", Key: "Tom"}, res); err != nil || string(res.Value) != "local-Tom" {
+		t.Fatalf("the replica should load Tom locally: %s %v", res.Value, err)
+	}
+	if dead.gets != 0 || localLoads.Load() != 1 {
+		t.Fatalf("the replica should not retry the dead owner, dead gets %d, local %d", dead.gets, localLoads.Load())
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	var loads atomic.Int64
+	getter := GetterHandler(func(key string) ([]byte, error) {
+		loads.Add(1)
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	})
+	gee := NewGroup("negative-scores", 2<<10, getter, WithNegativeTTL(50*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("unknown should be not found, got %v", err)
+		}
+	}
+	if loads.Load() != 1 || gee.Stats.NegativeHits.Load() != 2 {
+		t.Fatalf("missing key should reach the getter once, loads %d, negative hits %d", loads.Load(), gee.Stats.NegativeHits.Load())
+	}
+
+	//todo negative记录有自己的过期时间
+	time.Sleep(60 * time.Millisecond)
+	if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) || loads.Load() != 2 {
+		t.Fatalf("expired negative entry should be loaded again, loads %d, err %v", loads.Load(), err)
+	}
+
+	//todo Set之后不再是negative记录
+	if err := gee.Set(context.Background(), "unknown", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("unknown"); err != nil || view.String() != "1" {
+		t.Fatalf("unknown should be set, got %v %v", view, err)
+	}
+
+	//todo 大量不存在的key不会挤掉mainCache中的记录
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		_, _ = gee.Get(fmt.Sprintf("missing%d", i))
+	}
+	if _, ok := gee.mainCache.get("Tom"); !ok {
+		t.Fatal("negative entries should not evict Tom from mainCache")
+	}
+	if gee.negativeCache.len() == 0 || gee.negativeCache.len() >= 1000 {
+		t.Fatalf("negativeCache should be bounded, got %d entries", gee.negativeCache.len())
+	}
+}
+
+func TestNegativeCacheDisabled(t *testing.T) {
+	var loads atomic.Int64
+	gee := NewGroup("no-negative-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return nil, ErrNotFound
+	}), WithNegativeTTL(0))
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatal(err)
+		}
+	}
+	if loads.Load() != 3 {
+		t.Fatalf("without negative caching every Get should reach the getter, got %d", loads.Load())
+	}
+}
+
+func TestNegativeCacheTinyBudget(t *testing.T) {
+	//todo cacheBytes/16为0，不能变成不限制容量的negativeCache
+	gee := NewGroup("tiny-negative-scores", 10, GetterHandler(func(key string) ([]byte, error) {
+		return nil, ErrNotFound
+	}), WithHotCache(0))
+	for i := 0; i < 100; i++ {
+		_, _ = gee.Get(fmt.Sprintf("missing%d", i))
+	}
+	if gee.negativeTTL != 0 || gee.negativeCache.len() != 0 {
+		t.Fatalf("negativeCache should be disabled, ttl %v, %d entries", gee.negativeTTL, gee.negativeCache.len())
+	}
+}
+
+type notFoundPeer struct {
+	fakePeer
+}
+
+func (n *notFoundPeer) Get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.gets++
+	return ErrNotFound
+}
+
+func TestNegativeCacheFromPeer(t *testing.T) {
+	var loads atomic.Int64
+	gee := NewGroup("peer-negative-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("local"), nil
+	}), WithReplicas(2))
+	owner, next := &notFoundPeer{}, &fakePeer{}
+	gee.RegisterPeers(&replicaPicker{fakePicker: fakePicker{owner: next}, replicas: []PeerGetter{owner, next}})
+
+	//todo owner确认key不存在时不会尝试其他owner，也不会回退到本地的getter
+	for i := 0; i < 2; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("unknown should be not found, got %v", err)
+		}
+	}
+	if owner.gets != 1 || next.gets != 0 || loads.Load() != 0 || gee.Stats.PeerErrors.Load() != 0 {
+		t.Fatalf("unexpected loads: owner %d, next %d, local %d, peer errors %d",
+			owner.gets, next.gets, loads.Load(), gee.Stats.PeerErrors.Load())
+	}
+}
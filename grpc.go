@@ -0,0 +1,351 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	//与fromPeerHeader相同，GrpcGetter发出的请求带上这个metadata，服务端直接在本地加载
+	fromPeerMetadata = "cache-from-peer"
+)
+
+// outgoingFromPeer 标记发给其他peer的请求
+func outgoingFromPeer(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, fromPeerMetadata, "1")
+}
+
+// incomingFromPeer 请求来自其他peer时返回withFromPeer(ctx)
+func incomingFromPeer(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(fromPeerMetadata)) > 0 {
+		return withFromPeer(ctx)
+	}
+	return ctx
+}
+
+// GRPCPool implements PeerPicker and pb.GroupCacheServer for a pool of gRPC peers.
+// 与HTTPPool不同，每个peer只建立一条长连接(HTTP/2)，后续请求都复用这条连接
+type GRPCPool struct {
+	pb.UnimplementedGroupCacheServer
+
+	// this peer's address, e.g. "127.0.0.1:8000"
+	self string
+	//gRPC服务只访问node中的Group
+	node    *Node
+	members *membership
+	mu      sync.Mutex
+	server  *grpc.Server
+
+	logger     *slog.Logger
+	logSampler sampler
+}
+
+type GRPCPoolOption func(*GRPCPool)
+
+// WithGRPCLogger 设置GRPCPool的日志，默认不输出日志
+func WithGRPCLogger(logger *slog.Logger) GRPCPoolOption {
+	return func(p *GRPCPool) {
+		p.logger = logger
+	}
+}
+
+// NewGRPCPool 创建访问defaultNode中Group的GRPCPool，与NewHTTPPool相同，会注册为之后在defaultNode中创建的Group的peers，
+// 这些Group不需要再调用RegisterPeers(重复调用会panic)
+func NewGRPCPool(self string, opts ...GRPCPoolOption) *GRPCPool {
+	return defaultNode.NewGRPCPool(self, opts...)
+}
+
+func newGRPCPool(node *Node, self string, opts ...GRPCPoolOption) *GRPCPool {
+	if self == "" {
+		panic(errors.New("grpc Pool self is nil \n"))
+	}
+	pool := &GRPCPool{
+		self: self,
+		node: node,
+	}
+	pool.members = newMembership(self, func(addr string) (PeerGetter, error) {
+		getter, err := NewGrpcGetter(addr)
+		if err != nil {
+			return nil, err
+		}
+		getter.requests = orDefault(node).peerRequests
+		getter.logger = pool.logger
+		return getter, nil
+	})
+	for _, opt := range opts {
+		opt(pool)
+	}
+	pool.logger = logging.OrDiscard(pool.logger).With("self", self)
+	pool.members.logger = pool.logger
+	//todo 与HTTPPool相同，self也在哈希环上，调用方SetPeers时不需要传入自己的地址
+	pool.members.add(self, self, 0)
+	return pool
+}
+
+// SetPeers 将peers加入哈希环，已经存在的peer会复用原来的连接
+// 注意Set已经被pb.GroupCacheServer的Set方法占用了
+func (p *GRPCPool) SetPeers(peers ...string) {
+	for _, peer := range peers {
+		p.members.add(peer, peer, 0)
+	}
+}
+
+// SetWeight 修改peer在哈希环上的权重，peer不存在时返回false
+func (p *GRPCPool) SetWeight(peer string, weight int) bool {
+	return p.members.setWeight(peer, weight)
+}
+
+// Watch 将服务发现的结点变化同步到哈希环中，直到events被关闭
+func (p *GRPCPool) Watch(events <-chan discovery.Event) {
+	for event := range events {
+		if p.members.apply(event) {
+			p.logger.Info("peers changed", "event", event.Type, "key", event.Key, "peer", event.Peer.Addr, "peers", p.members.addrs())
+		}
+	}
+}
+
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	if addr, getter, ok := p.members.pick(key); ok {
+		if sampled(context.Background(), p.logger, &p.logSampler) {
+			p.logger.Debug("pick peer", keyHash(key), "peer", addr)
+		}
+		return getter, true
+	}
+	return nil, false
+}
+
+func (p *GRPCPool) PickOwner(key string) (PeerGetter, bool) {
+	if _, getter, ok := p.members.owner(key); ok {
+		return getter, true
+	}
+	return nil, false
+}
+
+func (p *GRPCPool) PickReplicas(key string, n int) []PeerGetter {
+	return p.members.pickN(key, n)
+}
+
+func (p *GRPCPool) GetAll() []PeerGetter {
+	return p.members.all()
+}
+
+// Log 以Info级别输出日志，新代码应该直接使用WithGRPCLogger设置的slog.Logger
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	p.logger.Info(fmt.Sprintf(format, v...))
+}
+
+// logRequest 按采样输出收到的请求
+func (p *GRPCPool) logRequest(ctx context.Context, method, group string, attrs ...any) {
+	if sampled(ctx, p.logger, &p.logSampler) {
+		p.logger.Debug("serve request", append([]any{"method", method, "group", group}, attrs...)...)
+	}
+}
+
+// Get 实现pb.GroupCacheServer，供其他peer调用
+func (p *GRPCPool) Get(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+	p.logRequest(ctx, "Get", req.GetGroup(), keyHash(req.GetKey()))
+	group := orDefault(p.node).GetGroup(req.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no such group: %s", req.GetGroup())
+	}
+
+	view, err := group.GetContext(incomingFromPeer(ctx), req.GetKey())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{Value: view.ByteSlice(), Expire: view.expireUnixNano()}, nil
+}
+
+// Set 实现pb.GroupCacheServer，将值写入本结点的缓存
+func (p *GRPCPool) Set(ctx context.Context, req *pb.SetRequest) (*pb.Response, error) {
+	p.logRequest(ctx, "Set", req.GetGroup(), keyHash(req.GetKey()))
+	group := orDefault(p.node).GetGroup(req.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no such group: %s", req.GetGroup())
+	}
+	group.setLocally(req.GetKey(), req.GetValue(), req.GetExpire())
+	return &pb.Response{}, nil
+}
+
+// Remove 实现pb.GroupCacheServer，将key从本结点的缓存中删除
+func (p *GRPCPool) Remove(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+	p.logRequest(ctx, "Remove", req.GetGroup(), keyHash(req.GetKey()))
+	group := orDefault(p.node).GetGroup(req.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no such group: %s", req.GetGroup())
+	}
+	group.Invalidate(req.GetKey())
+	return &pb.Response{}, nil
+}
+
+// GetMulti 实现pb.GroupCacheServer，单个key的错误在response中返回
+func (p *GRPCPool) GetMulti(ctx context.Context, req *pb.GetMultiRequest) (*pb.GetMultiResponse, error) {
+	p.logRequest(ctx, "GetMulti", req.GetGroup(), "keys", len(req.GetKeys()))
+	group := orDefault(p.node).GetGroup(req.GetGroup())
+	if group == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "no such group: %s", req.GetGroup())
+	}
+	return multiResponse(req.GetKeys(), group.GetMulti(incomingFromPeer(ctx), req.GetKeys())), nil
+}
+
+// Serve 在lis上启动gRPC服务，阻塞直到服务停止
+func (p *GRPCPool) Serve(lis net.Listener) error {
+	p.mu.Lock()
+	if p.server == nil {
+		p.server = grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithPropagators(propagator))))
+		pb.RegisterGroupCacheServer(p.server, p)
+	}
+	server := p.server
+	p.mu.Unlock()
+	return server.Serve(lis)
+}
+
+// ListenAndServe 监听self地址并启动gRPC服务
+func (p *GRPCPool) ListenAndServe() error {
+	lis, err := net.Listen("tcp", p.self)
+	if err != nil {
+		return err
+	}
+	return p.Serve(lis)
+}
+
+// Stop 停止gRPC服务并关闭所有到其他peer的连接
+func (p *GRPCPool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.server != nil {
+		p.server.GracefulStop()
+	}
+	p.members.close()
+}
+
+type GrpcGetter struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+	//请求的耗时，属于创建它的Node，nil时记录到defaultNode
+	requests *prometheus.HistogramVec
+
+	logger     *slog.Logger
+	logSampler sampler
+}
+
+// NewGrpcGetter 创建到addr的连接，连接是懒建立的，第一次请求时才真正拨号
+func NewGrpcGetter(addr string) (*GrpcGetter, error) {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(otelgrpc.WithPropagators(propagator))))
+	if err != nil {
+		return nil, err
+	}
+	return &GrpcGetter{
+		addr:   addr,
+		conn:   conn,
+		client: pb.NewGroupCacheClient(conn),
+	}, nil
+}
+
+// Get ctx的deadline会由gRPC自动传递给对方，没有deadline时使用defaultPeerTimeout
+func (g *GrpcGetter) Get(ctx context.Context, req *pb.Request, res *pb.Response) (err error) {
+	start := time.Now()
+	defer func() {
+		observePeerRequest(g.requests, g.addr, "get", start, err)
+		g.logRequest(ctx, "get", start, err, "group", req.GetGroup(), keyHash(req.GetKey()))
+	}()
+	ctx, cancelFunc := withPeerTimeout(ctx)
+	defer cancelFunc()
+	response, err := g.client.Get(outgoingFromPeer(ctx), req)
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	res.Value = response.GetValue()
+	res.Expire = response.GetExpire()
+	return nil
+}
+
+func (g *GrpcGetter) GetMulti(ctx context.Context, req *pb.GetMultiRequest, res *pb.GetMultiResponse) (err error) {
+	start := time.Now()
+	defer func() {
+		observePeerRequest(g.requests, g.addr, "get_multi", start, err)
+		g.logRequest(ctx, "get_multi", start, err, "group", req.GetGroup(), "keys", len(req.GetKeys()))
+	}()
+	ctx, cancelFunc := withPeerTimeout(ctx)
+	defer cancelFunc()
+	response, err := g.client.GetMulti(outgoingFromPeer(ctx), req)
+	if err != nil {
+		return err
+	}
+	res.Results = response.GetResults()
+	return nil
+}
+
+func (g *GrpcGetter) Set(ctx context.Context, req *pb.SetRequest) (err error) {
+	start := time.Now()
+	defer func() {
+		observePeerRequest(g.requests, g.addr, "set", start, err)
+		g.logRequest(ctx, "set", start, err, "group", req.GetGroup(), keyHash(req.GetKey()))
+	}()
+	ctx, cancelFunc := withPeerTimeout(ctx)
+	defer cancelFunc()
+	_, err = g.client.Set(ctx, req)
+	return err
+}
+
+func (g *GrpcGetter) Remove(ctx context.Context, req *pb.Request) (err error) {
+	start := time.Now()
+	defer func() {
+		observePeerRequest(g.requests, g.addr, "remove", start, err)
+		g.logRequest(ctx, "remove", start, err, "group", req.GetGroup(), keyHash(req.GetKey()))
+	}()
+	ctx, cancelFunc := withPeerTimeout(ctx)
+	defer cancelFunc()
+	_, err = g.client.Remove(ctx, req)
+	return err
+}
+
+// logRequest 与HttpGetter.logRequest相同，失败的请求输出Warn日志，成功的请求按采样输出Debug日志
+func (g *GrpcGetter) logRequest(ctx context.Context, method string, start time.Time, err error, attrs ...any) {
+	logger := logging.OrDiscard(g.logger)
+	attrs = append(attrs, "peer", g.addr, "method", method, "latency", time.Since(start))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.Warn("peer request failed", append(attrs, "err", err)...)
+	} else if sampled(ctx, logger, &g.logSampler) {
+		logger.Debug("peer request", append(attrs, "found", err == nil)...)
+	}
+}
+
+// String 返回peer的地址，用于日志
+func (g *GrpcGetter) String() string {
+	return g.addr
+}
+
+func (g *GrpcGetter) Close() error {
+	return g.conn.Close()
+}
+
+var (
+	_ PeerPicker          = (*GRPCPool)(nil)
+	_ pb.GroupCacheServer = (*GRPCPool)(nil)
+	_ PeerGetter          = (*GrpcGetter)(nil)
+	_ BatchPeerGetter     = (*GrpcGetter)(nil)
+)
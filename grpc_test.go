@@ -0,0 +1,193 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGRPCPool(t *testing.T) {
+	NewGroup("grpc-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewGRPCPool(lis.Addr().String())
+	defer resetPool()
+	go func() {
+		_ = pool.Serve(lis)
+	}()
+	defer pool.Stop()
+
+	getter, err := NewGrpcGetter(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer getter.Close()
+
+	for k, v := range db {
+		res := &pb.Response{}
+		if err := getter.Get(context.Background(), &pb.Request{Group: "grpc-scores", Key: k}, res); err != nil || string(res.Value) != v {
+			t.Fatalf("grpc get %s failed: %v", k, err)
+		}
+	}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "grpc-scores", Key: "unknown"}, &pb.Response{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown should be ErrNotFound, got %v", err)
+	}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("no-such-group should be InvalidArgument, got %v", err)
+	}
+
+	ctx := context.Background()
+	if err := getter.Set(ctx, &pb.SetRequest{Group: "grpc-scores", Key: "Tom", Value: []byte("700")}); err != nil {
+		t.Fatal(err)
+	}
+	res := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "grpc-scores", Key: "Tom"}, res); err != nil || string(res.Value) != "700" {
+		t.Fatalf("grpc set Tom failed: %v", err)
+	}
+	if err := getter.Remove(ctx, &pb.Request{Group: "grpc-scores", Key: "Tom"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "grpc-scores", Key: "Tom"}, res); err != nil || string(res.Value) != db["Tom"] {
+		t.Fatalf("grpc remove Tom failed: %v", err)
+	}
+}
+
+func TestGRPCPoolPickPeer(t *testing.T) {
+	pool := NewGRPCPool("127.0.0.1:9001")
+	defer resetPool()
+	defer pool.Stop()
+	pool.SetPeers("127.0.0.1:9001", "127.0.0.1:9002")
+	pool.SetPeers("127.0.0.1:9002")
+	if len(pool.GetAll()) != 1 {
+		t.Fatalf("expect 1 getter, got %d", len(pool.GetAll()))
+	}
+
+	picked := 0
+	for i := 0; i < 100; i++ {
+		peer, ok := pool.PickPeer(fmt.Sprintf("key%d", i))
+		if !ok {
+			continue
+		}
+		if peer.(*GrpcGetter).addr != "127.0.0.1:9002" {
+			t.Fatalf("picked unexpected peer %s", peer.(*GrpcGetter).addr)
+		}
+		picked++
+	}
+	if picked == 0 || picked == 100 {
+		t.Fatalf("keys should be spread over both peers, picked remote %d times", picked)
+	}
+}
+
+func TestGRPCPoolSelfInRing(t *testing.T) {
+	nodes := []*Node{NewNode(), NewNode()}
+	pools := make([]*GRPCPool, 2)
+	listeners := make([]net.Listener, 2)
+	for i := range nodes {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = lis
+		pools[i] = nodes[i].NewGRPCPool(lis.Addr().String())
+		go func(i int) {
+			_ = pools[i].Serve(listeners[i])
+		}(i)
+		defer pools[i].Stop()
+	}
+	//todo 只传入对方的地址，self已经在哈希环上
+	pools[0].SetPeers(listeners[1].Addr().String())
+	pools[1].SetPeers(listeners[0].Addr().String())
+
+	loads := make([]atomic.Int64, 2)
+	groups := make([]*Group, 2)
+	for i, node := range nodes {
+		i := i
+		groups[i] = node.NewGroup("grpc-self", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+			loads[i].Add(1)
+			return []byte(key), nil
+		}))
+	}
+	const n = 50
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		for _, group := range groups {
+			if view, err := group.GetContext(ctx, key); err != nil || view.String() != key {
+				t.Fatalf("get %s failed: %v %v", key, view, err)
+			}
+		}
+	}
+	if loads[0].Load()+loads[1].Load() != n || loads[0].Load() == 0 || loads[1].Load() == 0 {
+		t.Fatalf("each key should be loaded once by its owner, loads %d %d", loads[0].Load(), loads[1].Load())
+	}
+}
+
+func TestNewGRPCPoolDefaultNode(t *testing.T) {
+	defer resetPool()
+	//todo 与NewHTTPPool相同，包级别的NewGRPCPool作用在defaultNode上，之后创建的Group自动使用它
+	pool := NewGRPCPool("127.0.0.1:9001")
+	defer pool.Stop()
+	gee := NewGroup("grpc-default-node-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	if gee.peers != PeerPicker(pool) {
+		t.Fatal("groups created after NewGRPCPool should use it as peers")
+	}
+}
+
+func TestGrpcGetterLogger(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode()
+	node.NewGroup("grpc-logger", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server := node.NewGRPCPool(lis.Addr().String())
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	//todo getter使用创建它的pool的日志
+	recorder := &logRecorder{}
+	logger := slog.New(slog.NewJSONHandler(recorder, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	pool := NewNode().NewGRPCPool("self", WithGRPCLogger(logger))
+	defer pool.Stop()
+	pool.SetPeers(lis.Addr().String())
+	getter := pool.GetAll()[0].(*GrpcGetter)
+
+	ctx := context.Background()
+	if err = getter.Get(ctx, &pb.Request{Group: "grpc-logger", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = getter.Get(ctx, &pb.Request{Group: "no-such-group", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatal("no-such-group should fail")
+	}
+	if requests := recorder.records(t, "peer request"); len(requests) != 1 || requests[0]["method"] != "get" || requests[0]["peer"] != lis.Addr().String() {
+		t.Fatalf("successful request should be logged at debug level, got %v", requests)
+	}
+	failures := recorder.records(t, "peer request failed")
+	if len(failures) != 1 || failures[0]["level"] != "WARN" || failures[0]["self"] != "self" || failures[0]["err"] == nil {
+		t.Fatalf("failed request should be logged, got %v", failures)
+	}
+}
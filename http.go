@@ -1,158 +1,270 @@
 package simpleCache
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
 	"github.com/thewisecirno/simple_distributed_cache/consistentHash"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
 	"github.com/thewisecirno/simple_distributed_cache/etcd"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 	"io"
-	"log"
-	"math/rand"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
-	defaultBasePath    = "/_cache/"
-	defaultWatcherTime = time.Second * 3
+	defaultBasePath = "/_cache/"
+	//Shutdown删除注册后等待其他结点收到删除事件的时间
+	defaultDrainDelay = time.Second
+	//Start收到信号后Shutdown的超时时间
+	defaultShutdownTimeout = time.Second * 30
+	//请求方剩余的超时时间，例如"1.5s"，服务端据此设置ctx的超时
+	timeoutHeader = "Cache-Timeout"
+	//HttpGetter发出的请求带上这个请求头，服务端直接在本地加载，不再转发给其他peer
+	fromPeerHeader = "Cache-From-Peer"
 )
 
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
 type HTTPPool struct {
 	// this peer's base URL, e.g. "https://example.net:8000"
-	self        string
-	basePath    string
-	mu          sync.Mutex
-	peers       *consistentHash.ConsistentHash
-	httpGetters map[string]*HttpGetter
+	self     string
+	basePath string
+	//ServeHTTP只访问node中的Group
+	node      *Node
+	members   *membership
+	discovery discovery.Discovery
+	stopWatch context.CancelFunc
+	//正在处理的来自其他结点的Get请求数，作为self的负载
+	serving atomic.Int64
+	//self的权重
+	weight int
+
+	logger *slog.Logger
+	//选择peer、处理请求等热点路径的日志采样
+	logSampler sampler
+
+	//Shutdown的配置，见WithDrainDelay和WithHandoff
+	drainDelay time.Duration
+	handoff    int
+	mu         sync.Mutex
+	//Serve创建的服务器，Shutdown时等待正在处理的请求完成
+	server *http.Server
+	//Shutdown之后不能再Serve，否则启动的服务器没有人关闭
+	shutdown bool
 }
 
-var (
-	Pool    *HTTPPool
-	keyName string
-)
+// ErrPoolShutdown Shutdown之后调用Serve、ListenAndServe或Start
+var ErrPoolShutdown = errors.New("http pool is shut down")
 
-// NewHTTPPoolWithEtcd todo NewHttpPoolWithEtcd，省去还需要初始化Etcd的步骤
-func NewHTTPPoolWithEtcd(self string, base string, configEtcd *etcd.ConfigEtcd) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("[NewHTTPPoolWithEtcd] panic!")
-		}
-	}()
+type HTTPPoolOption func(*HTTPPool)
 
-	configEtcd.InitDiscovery(configEtcd.EndPoints, configEtcd.DialTimeout)
+// WithBoundedLoad 开启bounded load的一致性哈希，factor为每个结点的负载相对于平均负载的上限(例如1.25)，
+// 负载为正在进行的请求数，热点key会被分散到顺时针方向的下一个结点，factor为0时关闭
+func WithBoundedLoad(factor float64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		if factor != 0 && factor <= 1 {
+			panic(errors.New("bounded load factor must be greater than 1"))
+		}
+		p.members.loadFactor = factor
+	}
+}
 
-	if self == "" {
-		panic(errors.New("http Pool self is nil \n"))
+// WithPlacement 使用placement决定key由哪个结点负责，默认为consistentHash.NewConsistentHash(consistentHash.DefaultReplicas, nil)
+// placement应该是新创建的，不包含任何结点
+func WithPlacement(placement consistentHash.Placement) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.members.peers = placement
 	}
+}
 
-	if base == "" {
-		base = defaultBasePath
+// WithWeight 设置self的权重，注册到注册中心后其他结点按权重分配key，例如内存是其他结点两倍的结点可以设置为2
+func WithWeight(weight int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.weight = weight
 	}
+}
 
-	if configEtcd.WatcherTime == 0 {
-		configEtcd.WatcherTime = defaultWatcherTime
+// WithHTTPLogger 设置HTTPPool以及它创建的HttpGetter的日志，默认不输出日志
+func WithHTTPLogger(logger *slog.Logger) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.logger = logger
 	}
+}
 
-	Pool = &HTTPPool{
-		self:     self,
-		basePath: base,
-		peers:    consistentHash.NewConsistentHash(consistentHash.DefaultReplicas, nil),
+// WithDrainDelay Shutdown从注册中心删除self后等待delay再关闭服务器，让其他结点有时间收到删除事件，默认为defaultDrainDelay
+func WithDrainDelay(delay time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.drainDelay = delay
 	}
+}
 
-	if etcd.Client == nil {
-		panic(errors.New("etcd client is nil"))
+// WithHandoff Shutdown时把每个Group的mainCache中最热的n个key写到它们新的owner上，新owner不需要再访问数据库，0表示不交接(默认)
+func WithHandoff(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.handoff = n
 	}
-	//todo 从etcd获取分布式结点，此时etcd中还不包含self
-	timeout, cancelFunc := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancelFunc()
-	get, err := etcd.Client.Get(timeout, "Cache&", clientv3.WithPrefix())
+}
+
+// Pool defaultNode的HTTPPool，由包级别的NewHTTPPool设置
+var Pool *HTTPPool
+
+// NewHTTPPoolWithEtcd todo NewHttpPoolWithEtcd，省去还需要初始化Etcd的步骤，etcd client属于defaultNode
+func NewHTTPPoolWithEtcd(self string, base string, configEtcd *etcd.ConfigEtcd, opts ...HTTPPoolOption) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("NewHTTPPoolWithEtcd panic", "err", r)
+		}
+	}()
+
+	pool, err := defaultNode.NewHTTPPoolWithEtcd(self, base, configEtcd, opts...)
 	if err != nil {
-		log.Println("[NewHttpPool] etcd get error", err)
 		panic(err)
 	}
+	Pool = pool
+}
+
+// NewHTTPPool 在defaultNode中创建HTTPPool并设置为全局的Pool，通过d发现其他结点，d为nil时只包含self
+func NewHTTPPool(self string, base string, d discovery.Discovery, opts ...HTTPPoolOption) *HTTPPool {
+	pool := defaultNode.NewHTTPPool(self, base, d, opts...)
+	Pool = pool
+	return pool
+}
 
-	//todo 将self加入etcd
-	keyName = "Cache&" + strconv.Itoa(int(time.Now().Unix())) + strconv.Itoa(rand.Intn(1000000))
-	timeout1, cancelFunc1 := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancelFunc1()
-	if _, err = etcd.Client.Put(timeout1, keyName, self); err != nil {
-		log.Println(err)
+func newHTTPPool(node *Node, self string, base string, d discovery.Discovery, opts ...HTTPPoolOption) *HTTPPool {
+	if self == "" {
+		panic(errors.New("http Pool self is nil \n"))
 	}
 
-	//todo 将各种结点接入Pool内
-	Pool.Set(self)
-	for _, kv := range get.Kvs {
-		Pool.Set(string(kv.Value))
+	if base == "" {
+		base = defaultBasePath
 	}
 
-	go func() {
+	pool := &HTTPPool{
+		self:       self,
+		basePath:   base,
+		node:       node,
+		discovery:  d,
+		drainDelay: defaultDrainDelay,
+	}
+	pool.members = newMembership(self, func(addr string) (PeerGetter, error) {
+		return &HttpGetter{baseURL: addr + base, logger: pool.logger, requests: orDefault(node).peerRequests}, nil
+	})
+	pool.members.selfLoad = pool.serving.Load
+	for _, opt := range opts {
+		opt(pool)
+	}
+	pool.logger = logging.OrDiscard(pool.logger).With("self", self)
+	pool.members.logger = pool.logger
+	pool.members.add(self, self, pool.weight)
+
+	if d != nil {
+		//todo 先获取已有的结点再注册self
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		events, err := d.Watch(ctx)
+		if err != nil {
+			cancelFunc()
+			pool.logger.Error("discovery watch failed", "err", err)
+			panic(err)
+		}
+		pool.stopWatch = cancelFunc
 
-		defer log.Println("[etcd Watcher] finished!")
-		for {
-			ctx := context.TODO()
-			log.Println("watch")
-
-			//todo 设置要监听的键前缀
-			prefix := "Cache&"
-
-			//todo 创建一个 Watcher
-			watcher := etcd.Client.Watch(ctx, prefix, clientv3.WithPrefix())
-
-			//todo 循环监听事件
-			for resp := range watcher {
-				for _, event := range resp.Events {
-					switch event.Type {
-					case clientv3.EventTypePut:
-						log.Println("watch put", event.Kv)
-						Pool.Set(string(event.Kv.Value))
-					case clientv3.EventTypeDelete:
-						log.Println("watch delete", event.Kv)
-						Pool.peers.Del(string(event.Kv.Key))
-					}
-				}
-			}
-			time.Sleep(configEtcd.WatcherTime)
+		timeout, cancelFunc1 := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancelFunc1()
+		if err = d.Register(timeout, discovery.Peer{Addr: self, Weight: pool.weight}); err != nil {
+			pool.logger.Error("register failed", "err", err)
 		}
-	}()
+		go pool.watch(events)
+	}
+	return pool
+}
+
+// watch 将结点变化同步到哈希环中
+func (p *HTTPPool) watch(events <-chan discovery.Event) {
+	for event := range events {
+		if p.members.apply(event) {
+			p.log().Info("peers changed", "event", event.Type, "key", event.Key, "peer", event.Peer.Addr, "peers", p.members.addrs())
+		}
+	}
 }
 
+// Set 将peers加入哈希环，已经存在的peer不会重复加入
+// 静态配置的peer以地址本身作为注册key
 func (p *HTTPPool) Set(peers ...string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*HttpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &HttpGetter{baseURL: peer + p.basePath}
+		p.members.add(peer, peer, 0)
+	}
+}
+
+// SetWeight 修改peer在哈希环上的权重，peer不存在时返回false
+// peer为self并且Discovery支持WeightSetter时，同时更新注册中心中的权重，其他结点也会随之更新
+func (p *HTTPPool) SetWeight(peer string, weight int) bool {
+	if !p.members.setWeight(peer, weight) {
+		return false
+	}
+	if setter, ok := p.discovery.(discovery.WeightSetter); ok && peer == p.self {
+		timeout, cancelFunc := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancelFunc()
+		if err := setter.SetWeight(timeout, weight); err != nil {
+			p.log().Warn("publish weight failed", "weight", weight, "err", err)
+		}
 	}
+	return true
 }
 
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
-	log.Println("[HTTPPool] PickPeer")
-	log.Printf("%#v", p.peers)
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if get := p.peers.Get(key); get != "" && get != p.self {
-		p.Log("Pick peer %s", get)
-		return p.httpGetters[get], true
+	if addr, getter, ok := p.members.pick(key); ok {
+		if sampled(context.Background(), p.log(), &p.logSampler) {
+			p.log().Debug("pick peer", keyHash(key), "peer", addr)
+		}
+		return getter, true
 	}
-	p.Log("can't Pick anything,the get is %s ", p.peers.Get(key))
 	return nil, false
 }
 
+// PickOwner 不受WithBoundedLoad影响，用于Set和Remove
+func (p *HTTPPool) PickOwner(key string) (PeerGetter, bool) {
+	if _, getter, ok := p.members.owner(key); ok {
+		return getter, true
+	}
+	return nil, false
+}
+
+// PickReplicas 不受WithBoundedLoad影响
+func (p *HTTPPool) PickReplicas(key string, n int) []PeerGetter {
+	return p.members.pickN(key, n)
+}
+
+func (p *HTTPPool) GetAll() []PeerGetter {
+	return p.members.all()
+}
+
+// Log 以Info级别输出日志，新代码应该直接使用WithHTTPLogger设置的slog.Logger
 func (p *HTTPPool) Log(format string, v ...interface{}) {
-	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+	p.log().Info(fmt.Sprintf(format, v...))
+}
+
+func (p *HTTPPool) log() *slog.Logger {
+	if p == nil {
+		return logging.OrDiscard(nil)
+	}
+	return logging.OrDiscard(p.logger)
 }
 
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -161,10 +273,19 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == defaultMetricsPath {
+		orDefault(p.node).MetricsHandler().ServeHTTP(w, r)
+		return
+	}
+
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
-	p.Log("%s %s", r.Method, r.URL.Path)
+	//todo 从请求头中恢复调用方的trace，本结点的span都是它的子span
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := startSpan(ctx, "HTTPPool.ServeHTTP", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.method", r.Method)))
+	defer span.End()
 	// /<basepath>/<groupname>/<key> required
 	// default base path is _cache
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
@@ -175,21 +296,105 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	groupName := parts[0]
 	key := parts[1]
+	//todo http.target不包含key，与日志一样只记录key的哈希值
+	span.SetAttributes(attribute.String("http.target", p.basePath+groupName+"/"), attribute.String("cache.group", groupName))
+	if key != "" {
+		span.SetAttributes(attribute.String("key_hash", hashKey(key)))
+	}
+	if sampled(ctx, p.log(), &p.logSampler) {
+		p.log().Debug("serve request", "method", r.Method, "group", groupName, keyHash(key))
+	}
 
-	group := GetGroup(groupName)
+	//todo GET获取、POST批量获取、PUT写入、DELETE删除，其他方法不能当作GET处理
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	group := orDefault(p.node).GetGroup(groupName)
 	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		http.Error(w, "no such group: "+groupName, http.StatusBadRequest)
 		return
 	}
 
-	view, err := group.Get(key)
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		//todo 与Group.Set和Group.Remove一样，写入和删除需要key
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setReq := &pb.SetRequest{}
+		if err = proto.Unmarshal(body, setReq); err != nil {
+			http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		group.setLocally(key, setReq.GetValue(), setReq.GetExpire())
+		return
+	case http.MethodDelete:
+		group.Invalidate(key)
+		return
+	}
+
+	p.serving.Add(1)
+	defer p.serving.Add(-1)
+	if r.Header.Get(fromPeerHeader) != "" {
+		ctx = withFromPeer(ctx)
+	}
+	if timeout, err := time.ParseDuration(r.Header.Get(timeoutHeader)); err == nil {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, timeout)
+		defer cancelFunc()
+	}
+
+	//todo POST /<basepath>/<groupname>/ 批量获取，请求体为pb.GetMultiRequest
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		multiReq := &pb.GetMultiRequest{}
+		if err = proto.Unmarshal(body, multiReq); err != nil {
+			http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		protoRes, err := proto.Marshal(multiResponse(multiReq.GetKeys(), group.GetMulti(ctx, multiReq.GetKeys())))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(protoRes)
+		return
+	}
+
+	view, err := group.GetContext(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		//todo 404只表示key不存在，不存在的group是400
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	protoRes, err := proto.Marshal(&pb.Response{
-		Value: view.ByteSlice(),
+		Value:  view.ByteSlice(),
+		Expire: view.expireUnixNano(),
 	})
 	w.Header().Set("Content-Type", "application/octet-stream")
 	_, err = w.Write(protoRes)
@@ -200,17 +405,71 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 type HttpGetter struct {
 	baseURL string
+	//正在进行的Get请求数
+	inflight atomic.Int64
+
+	logger     *slog.Logger
+	logSampler sampler
+	//请求的耗时，属于创建它的Node，nil时记录到defaultNode
+	requests *prometheus.HistogramVec
+}
+
+// String 返回peer的地址，用于日志
+func (h *HttpGetter) String() string {
+	return h.baseURL
+}
+
+// logRequest 失败的请求输出Warn日志，成功的请求按采样输出Debug日志
+func (h *HttpGetter) logRequest(ctx context.Context, method string, start time.Time, err error, attrs ...any) {
+	logger := logging.OrDiscard(h.logger)
+	attrs = append(attrs, "peer", h.baseURL, "method", method, "latency", time.Since(start))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.Warn("peer request failed", append(attrs, "err", err)...)
+	} else if sampled(ctx, logger, &h.logSampler) {
+		logger.Debug("peer request", append(attrs, "found", err == nil)...)
+	}
+}
+
+// Load 返回正在进行的Get请求数，用于bounded load
+func (h *HttpGetter) Load() int64 {
+	return h.inflight.Load()
 }
 
-func (h *HttpGetter) Get(req *pb.Request, res *pb.Response) error {
-	getUrl := fmt.Sprintf("http://%v%v/%v",
+func (h *HttpGetter) url(group, key string) string {
+	return fmt.Sprintf("http://%v%v/%v",
 		h.baseURL,
-		url.QueryEscape(req.GetGroup()),
-		url.QueryEscape(req.GetKey()))
-	response, err := http.Get(getUrl)
-	log.Println("[GET]", getUrl)
+		url.QueryEscape(group),
+		url.QueryEscape(key))
+}
+
+func (h *HttpGetter) Get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "HttpGetter.Get", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("peer", h.baseURL)))
+	setKeyAttributes(span, req.GetGroup(), req.GetKey())
+	err := h.get(ctx, req, res)
+	endSpan(span, err)
+	observePeerRequest(h.requests, h.baseURL, "get", start, err)
+	h.logRequest(ctx, "get", start, err, "group", req.GetGroup(), keyHash(req.GetKey()))
+	return err
+}
+
+func (h *HttpGetter) get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	getUrl := h.url(req.GetGroup(), req.GetKey())
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, getUrl, nil)
+	if err != nil {
+		return err
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	request.Header.Set(fromPeerHeader, "1")
+	//todo 将剩余的超时时间告诉对方，让对方的getter也能及时放弃
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Header.Set(timeoutHeader, time.Until(deadline).String())
+	}
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	defer func(Body io.ReadCloser) {
@@ -220,6 +479,9 @@ func (h *HttpGetter) Get(req *pb.Request, res *pb.Response) error {
 		}
 	}(response.Body)
 
+	if response.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
 	if response.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned: %v", response.Status)
 	}
@@ -232,31 +494,244 @@ func (h *HttpGetter) Get(req *pb.Request, res *pb.Response) error {
 	if err = proto.Unmarshal(bytes, res); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
-	log.Println("[GET]", res)
 	return nil
 }
 
-// Start todo 启动结点服务
+// GetMulti 一次POST获取多个key，单个key的错误在response中返回
+func (h *HttpGetter) GetMulti(ctx context.Context, req *pb.GetMultiRequest, res *pb.GetMultiResponse) error {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "HttpGetter.GetMulti", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("cache.group", req.GetGroup()), attribute.Int("cache.keys", len(req.GetKeys())), attribute.String("peer", h.baseURL)))
+	err := h.getMulti(ctx, req, res)
+	endSpan(span, err)
+	observePeerRequest(h.requests, h.baseURL, "get_multi", start, err)
+	h.logRequest(ctx, "get_multi", start, err, "group", req.GetGroup(), "keys", len(req.GetKeys()))
+	return err
+}
+
+func (h *HttpGetter) getMulti(ctx context.Context, req *pb.GetMultiRequest, res *pb.GetMultiResponse) error {
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	postUrl := h.url(req.GetGroup(), "")
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, postUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	request.Header.Set(fromPeerHeader, "1")
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Header.Set(timeoutHeader, time.Until(deadline).String())
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(data, res); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// Set 与GrpcGetter相同，ctx没有deadline时使用defaultPeerTimeout
+func (h *HttpGetter) Set(ctx context.Context, req *pb.SetRequest) error {
+	ctx, cancelFunc := withPeerTimeout(ctx)
+	defer cancelFunc()
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, h.url(req.GetGroup(), req.GetKey()), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	return h.do("set", request, "group", req.GetGroup(), keyHash(req.GetKey()))
+}
+
+// Remove 与GrpcGetter相同，ctx没有deadline时使用defaultPeerTimeout
+func (h *HttpGetter) Remove(ctx context.Context, req *pb.Request) error {
+	ctx, cancelFunc := withPeerTimeout(ctx)
+	defer cancelFunc()
+	request, err := http.NewRequestWithContext(ctx, http.MethodDelete, h.url(req.GetGroup(), req.GetKey()), nil)
+	if err != nil {
+		return err
+	}
+	return h.do("remove", request, "group", req.GetGroup(), keyHash(req.GetKey()))
+}
+
+// do 发送不需要解析响应体的请求，与Get一样记录耗时和日志
+func (h *HttpGetter) do(method string, request *http.Request, attrs ...any) error {
+	ctx := request.Context()
+	propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	start := time.Now()
+	err := h.send(request)
+	observePeerRequest(h.requests, h.baseURL, method, start, err)
+	h.logRequest(ctx, method, start, err, attrs...)
+	return err
+}
+
+func (h *HttpGetter) send(request *http.Request) error {
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", response.Status)
+	}
+	return nil
+}
+
+// ListenAndServe 在addr上提供服务，Shutdown之后返回nil
+func (p *HTTPPool) ListenAndServe(addr string) error {
+	if p.isShutdown() {
+		return ErrPoolShutdown
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(listener)
+}
+
+// Serve 在listener上提供服务，Shutdown之后返回nil，已经Shutdown时关闭listener并返回ErrPoolShutdown
+func (p *HTTPPool) Serve(listener net.Listener) error {
+	server := &http.Server{Handler: p}
+	p.mu.Lock()
+	if p.shutdown {
+		p.mu.Unlock()
+		_ = listener.Close()
+		return ErrPoolShutdown
+	}
+	p.server = server
+	p.mu.Unlock()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 优雅退出，ctx超时后不再等待：
+// 1. 从注册中心删除self并停止watch，其他结点收到删除事件后不再把请求发给self
+// 2. 开启WithHandoff时把热点key交给self离开后的owner
+// 3. 等待drainDelay，期间仍然正常处理请求，self在自己的哈希环中保持不变，不会与还没有收到删除事件的结点互相转发
+// 4. 关闭服务器，等待正在处理的请求完成
+func (p *HTTPPool) Shutdown(ctx context.Context) error {
+	//todo 先标记，Shutdown时还没有Serve的话，之后的Serve也不会再启动服务器
+	p.mu.Lock()
+	p.shutdown = true
+	p.mu.Unlock()
+	var errs []error
+	drained := time.After(p.drainDelay)
+	if p.discovery != nil {
+		if err := p.discovery.Deregister(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("deregister: %w", err))
+		}
+		p.stopWatch()
+	}
+	if p.handoff > 0 {
+		p.handoffHotKeys(ctx)
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	server := p.server
+	p.mu.Unlock()
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown server: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *HTTPPool) isShutdown() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.shutdown
+}
+
+// handoffHotKeys 把每个Group的mainCache中最热的p.handoff个key写到新的owner上，失败的key只记录日志
+func (p *HTTPPool) handoffHotKeys(ctx context.Context) {
+	now := time.Now()
+	for _, group := range orDefault(p.node).Groups() {
+		handed := 0
+		for _, hot := range group.mainCache.hottest(p.handoff) {
+			key, view := hot.key, hot.view
+			if view.expired(now) {
+				continue
+			}
+			peer, ok := p.members.successor(key)
+			if !ok {
+				//todo 这个key没有其他结点可以接收，继续处理其他key
+				continue
+			}
+			err := peer.Set(ctx, &pb.SetRequest{Group: group.name, Key: key, Value: view.ByteSlice(), Expire: view.expireUnixNano()})
+			if err != nil {
+				p.log().Warn("hand off key failed", "group", group.name, keyHash(key), peerAttr(peer), "err", err)
+				continue
+			}
+			handed++
+		}
+		p.log().Info("handed off hot keys", "group", group.name, "keys", handed)
+	}
+}
+
+// Start todo 启动结点服务，收到SIGINT或SIGTERM后Shutdown
 func Start(address string) {
+	//todo 使用启动时的pool，避免全局的Pool被替换或者为nil
+	pool := Pool
+	if pool == nil {
+		panic("no HTTPPool, call NewHTTPPool before Start")
+	}
+	if pool.isShutdown() {
+		pool.log().Error("cache Start failed", "err", ErrPoolShutdown)
+		return
+	}
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("[cache Start] panic", r)
+			pool.log().Error("cache Start panic", "err", r)
 		}
 	}()
 
 	go func() {
-		log.Println(http.ListenAndServe(address, Pool))
+		if err := pool.ListenAndServe(address); err != nil {
+			pool.log().Error("serve failed", "err", err)
+		}
 	}()
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
-	log.Println("cache server stop success...")
-	timeout, cancelFunc := context.WithTimeout(context.Background(), time.Second*5)
+	pool.log().Info("cache server stopping")
+	timeout, cancelFunc := context.WithTimeout(context.Background(), defaultShutdownTimeout)
 	defer cancelFunc()
-	if _, err := etcd.Client.Delete(timeout, keyName); err != nil {
-		log.Println(err)
-		panic(err)
+	if err := pool.Shutdown(timeout); err != nil {
+		pool.log().Error("shutdown failed", "err", err)
 	}
+	if err := defaultNode.Close(); err != nil {
+		pool.log().Error("close etcd client failed", "err", err)
+	}
+	pool.log().Info("cache server stopped")
 }
 
-var _ PeerGetter = (*HttpGetter)(nil)
+var (
+	_ PeerGetter      = (*HttpGetter)(nil)
+	_ BatchPeerGetter = (*HttpGetter)(nil)
+	_ ReplicaPicker   = (*HTTPPool)(nil)
+)
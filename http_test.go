@@ -0,0 +1,460 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPPoolSetRemove(t *testing.T) {
+	NewGroup("http-scores", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	getter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+
+	ctx := context.Background()
+	if err := getter.Set(ctx, &pb.SetRequest{Group: "http-scores", Key: "Sam", Value: []byte("600")}); err != nil {
+		t.Fatal(err)
+	}
+	res := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "http-scores", Key: "Sam"}, res); err != nil || string(res.Value) != "600" {
+		t.Fatalf("http set Sam failed: %v", err)
+	}
+	if err := getter.Remove(ctx, &pb.Request{Group: "http-scores", Key: "Sam"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "http-scores", Key: "Sam"}, res); err != nil || string(res.Value) != db["Sam"] {
+		t.Fatalf("http remove Sam failed: %v", err)
+	}
+	if err := getter.Remove(ctx, &pb.Request{Group: "no-such-group", Key: "Sam"}); err == nil {
+		t.Fatal("no-such-group should return error")
+	}
+
+	//todo key为空时返回400，不会写入或删除空key
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		request, err := http.NewRequest(method, server.URL+defaultBasePath+"http-scores/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s without a key should return 400, got %d", method, response.StatusCode)
+		}
+	}
+	if err := getter.Set(ctx, &pb.SetRequest{Group: "http-scores", Key: "", Value: []byte("600")}); err == nil {
+		t.Fatal("set without a key should fail")
+	}
+}
+
+func TestHTTPPoolMethodNotAllowed(t *testing.T) {
+	NewGroup("http-methods", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+
+	for _, method := range []string{http.MethodPatch, http.MethodHead, http.MethodOptions} {
+		request, err := http.NewRequest(method, server.URL+defaultBasePath+"http-methods/Tom", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusMethodNotAllowed {
+			t.Fatalf("%s should return 405, got %d", method, response.StatusCode)
+		}
+	}
+}
+
+func TestHTTPDeadlinePropagation(t *testing.T) {
+	deadlines := make(chan time.Duration, 1)
+	NewGroup("http-deadline", 2<<10, ContextGetterHandler(
+		func(ctx context.Context, key string) ([]byte, error) {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				return nil, errors.New("no deadline")
+			}
+			deadlines <- time.Until(deadline)
+			return []byte(key), nil
+		}))
+
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	getter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFunc()
+	res := &pb.Response{}
+	if err := getter.Get(ctx, &pb.Request{Group: "http-deadline", Key: "Tom"}, res); err != nil || string(res.Value) != "Tom" {
+		t.Fatalf("http get Tom failed: %v", err)
+	}
+	if remaining := <-deadlines; remaining <= 0 || remaining > time.Second {
+		t.Fatalf("remote getter should see the caller's deadline, got %v", remaining)
+	}
+}
+
+func TestNewHTTPPoolWithDiscovery(t *testing.T) {
+	defer func() {
+		resetPool()
+	}()
+	pool := NewHTTPPool("127.0.0.1:9001", "", discovery.NewStatic("127.0.0.1:9002", "127.0.0.1:9003"))
+	defer pool.stopWatch()
+	if Pool != pool {
+		t.Fatal("NewHTTPPool should set the global Pool")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		picked := pool.members.addrs()
+		if len(picked) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("all three peers should be in the ring, got %v", picked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPPoolBoundedLoad(t *testing.T) {
+	defer func() {
+		resetPool()
+	}()
+	const factor = 1.25
+	pool := NewHTTPPool("127.0.0.1:8000", "", nil, WithBoundedLoad(factor))
+	for i := 1; i < 10; i++ {
+		pool.Set(fmt.Sprintf("127.0.0.1:%d", 8000+i))
+	}
+
+	//todo 热点key的请求一直没有返回，模拟正在进行的请求
+	total := 0
+	for i := 0; total < 5000; i++ {
+		for j := 0; j < 500/(i+1)+1; j++ {
+			if getter, ok := pool.PickPeer(fmt.Sprintf("key%d", i)); ok {
+				getter.(*HttpGetter).inflight.Add(1)
+			} else {
+				pool.serving.Add(1)
+			}
+			total++
+		}
+	}
+
+	max := pool.serving.Load()
+	for _, getter := range pool.GetAll() {
+		if load := getter.(*HttpGetter).Load(); load > max {
+			max = load
+		}
+	}
+	ratio := float64(max) / (float64(total) / 10)
+	if ratio > factor+0.01 {
+		t.Fatalf("max/avg load %.2f exceeds factor %.2f", ratio, factor)
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+	NewGroup("http-missing", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}))
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	getter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+
+	res := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "http-missing", Key: "Tom"}, res); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing key should be ErrNotFound, got %v", err)
+	}
+
+	//todo 不存在的group是400，不能当作key不存在
+	response, err := http.Get(server.URL + defaultBasePath + "no-such-group/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("missing group should be 400, got %d", response.StatusCode)
+	}
+	if err = getter.Get(context.Background(), &pb.Request{Group: "no-such-group", Key: "Tom"}, res); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing group should not be ErrNotFound, got %v", err)
+	}
+}
+
+func TestHTTPPoolShutdown(t *testing.T) {
+	memory := discovery.NewMemory()
+	listeners := make([]net.Listener, 2)
+	addrs := make([]string, 2)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i], addrs[i] = listener, listener.Addr().String()
+	}
+	nodeA, nodeB := NewNode(), NewNode()
+	a := nodeA.NewHTTPPool(addrs[0], "", memory.Client(), WithDrainDelay(20*time.Millisecond), WithHandoff(100))
+	b := nodeB.NewHTTPPool(addrs[1], "", memory.Client(), WithDrainDelay(0))
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(listeners[0])
+	}()
+	go func() {
+		_ = b.Serve(listeners[1])
+	}()
+	defer b.Shutdown(context.Background())
+	waitPicked(t, a, addrs[0], addrs[1]+defaultBasePath)
+	waitPicked(t, b, addrs[1], addrs[0]+defaultBasePath)
+
+	var owned []string
+	for i := 0; len(owned) < 20; i++ {
+		if _, ok := b.PickPeer(fmt.Sprintf("key%d", i)); ok {
+			owned = append(owned, fmt.Sprintf("key%d", i))
+		}
+	}
+	slowKey, hotKeys := owned[0], owned[1:]
+	started, release := make(chan struct{}), make(chan struct{})
+	groupA := nodeA.NewGroup("shutdown", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		if key == slowKey {
+			close(started)
+			<-release
+		}
+		return []byte(key), nil
+	}))
+	var loadsB atomic.Int64
+	groupB := nodeB.NewGroup("shutdown", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		loadsB.Add(1)
+		return []byte(key), nil
+	}))
+	for _, key := range hotKeys {
+		if _, err := groupA.Get(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	slow := make(chan error, 1)
+	go func() {
+		view, err := groupB.Get(slowKey)
+		if err == nil && view.String() != slowKey {
+			err = fmt.Errorf("unexpected value %s", view)
+		}
+		slow <- err
+	}()
+	<-started
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelFunc()
+		shutdown <- a.Shutdown(ctx)
+	}()
+
+	//todo 先从注册中心删除，b不再把请求发给a
+	waitPicked(t, b, addrs[1])
+	//todo 正在处理的请求完成之前Shutdown不会返回
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown should wait for in-flight requests, returned %v", err)
+	default:
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("in-flight request should not be cut: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve should return nil after Shutdown, got %v", err)
+	}
+
+	//todo 热点key已经交给b，不需要再访问数据库
+	for _, key := range hotKeys {
+		if view, err := groupB.Get(key); err != nil || view.String() != key {
+			t.Fatalf("get %s from b failed: %v %v", key, view, err)
+		}
+	}
+	if loadsB.Load() != 0 {
+		t.Fatalf("hot keys should be handed off to b, b loaded %d keys", loadsB.Load())
+	}
+	getter := &HttpGetter{baseURL: addrs[0] + defaultBasePath}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "shutdown", Key: hotKeys[0]}, &pb.Response{}); err == nil {
+		t.Fatal("a should not accept requests after Shutdown")
+	}
+}
+
+func TestHTTPPoolShutdownBeforeServe(t *testing.T) {
+	pool := NewNode().NewHTTPPool("127.0.0.1:9001", "", discovery.NewStatic(), WithDrainDelay(0))
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	//todo Shutdown之后Serve不再启动服务器，并关闭listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pool.Serve(listener); !errors.Is(err, ErrPoolShutdown) {
+		t.Fatalf("Serve after Shutdown should fail, got %v", err)
+	}
+	if _, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Fatal("listener should be closed")
+	}
+	if err = pool.ListenAndServe("127.0.0.1:0"); !errors.Is(err, ErrPoolShutdown) {
+		t.Fatalf("ListenAndServe after Shutdown should fail, got %v", err)
+	}
+}
+
+func TestHTTPPoolShutdownKeepsSelf(t *testing.T) {
+	_, pools, addrs := startHTTPNodes(t, 2, WithDrainDelay(300*time.Millisecond))
+	a := pools[0]
+	before := pickedAddrs(a)
+	if before[addrs[0]] == 0 {
+		t.Fatalf("a should own some keys, got %v", before)
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- a.Shutdown(context.Background())
+	}()
+
+	//todo Memory以self的地址为key注册，Deregister产生的删除事件不能把self移出自己的哈希环
+	time.Sleep(100 * time.Millisecond)
+	if len(a.members.addrs()) != 2 {
+		t.Fatalf("self should stay in its own ring during the drain, got %v", a.members.addrs())
+	}
+	if during := pickedAddrs(a); fmt.Sprint(during) != fmt.Sprint(before) {
+		t.Fatalf("keys owned by self should still resolve to self during the drain, before %v, got %v", before, during)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startHTTPNodes 在httptest服务器上启动n个互相发现的Node
+func startHTTPNodes(t *testing.T, n int, opts ...HTTPPoolOption) ([]*Node, []*HTTPPool, []string) {
+	t.Helper()
+	memory := discovery.NewMemory()
+	nodes := make([]*Node, n)
+	pools := make([]*HTTPPool, n)
+	addrs := make([]string, n)
+	for i := range nodes {
+		server := httptest.NewUnstartedServer(nil)
+		addrs[i] = server.Listener.Addr().String()
+		nodes[i] = NewNode()
+		pools[i] = nodes[i].NewHTTPPool(addrs[i], "", memory.Client(), opts...)
+		server.Config.Handler = pools[i]
+		server.Start()
+		t.Cleanup(server.Close)
+		t.Cleanup(pools[i].stopWatch)
+	}
+	for i, pool := range pools {
+		want := []string{addrs[i]}
+		for j, addr := range addrs {
+			if j != i {
+				want = append(want, addr+defaultBasePath)
+			}
+		}
+		waitPicked(t, pool, want...)
+	}
+	return nodes, pools, addrs
+}
+
+func TestHTTPPoolBoundedLoadNoBounce(t *testing.T) {
+	nodes, pools, _ := startHTTPNodes(t, 2, WithBoundedLoad(1.25))
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := pools[0].PickPeer(fmt.Sprintf("key%d", i)); ok {
+			key = fmt.Sprintf("key%d", i)
+		}
+	}
+	loads := make([]atomic.Int64, 2)
+	groups := make([]*Group, 2)
+	for i, node := range nodes {
+		i := i
+		groups[i] = node.NewGroup("bounded-bounce", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+			loads[i].Add(1)
+			return []byte(key), nil
+		}))
+	}
+
+	//todo owner(b)过载，把key分给a；a只看到自己发给b的请求数，仍然认为b是owner
+	pools[1].serving.Add(100)
+	defer pools[1].serving.Add(-100)
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFunc()
+	view, err := groups[1].GetContext(ctx, key)
+	if err != nil || view.String() != key {
+		t.Fatalf("get %s failed: %v %v", key, view, err)
+	}
+	//todo a收到转发来的请求后直接在本地加载，不会再转发回b
+	if loads[0].Load() != 1 || loads[1].Load() != 0 {
+		t.Fatalf("the spilled key should be loaded once by a, loads %d %d", loads[0].Load(), loads[1].Load())
+	}
+	if groups[0].Stats.PeerLoads.Load() != 0 || groups[1].Stats.PeerLoads.Load() != 1 {
+		t.Fatalf("the request should take exactly one hop, peer loads %d %d",
+			groups[0].Stats.PeerLoads.Load(), groups[1].Stats.PeerLoads.Load())
+	}
+}
+
+func TestHTTPPoolBoundedLoadSet(t *testing.T) {
+	nodes, pools, _ := startHTTPNodes(t, 2, WithBoundedLoad(1.25))
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := pools[0].PickPeer(fmt.Sprintf("key%d", i)); ok {
+			key = fmt.Sprintf("key%d", i)
+		}
+	}
+	groups := make([]*Group, 2)
+	for i, node := range nodes {
+		groups[i] = node.NewGroup("bounded-set", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	}
+
+	//todo b过载时PickPeer把读取分给a，但b仍然是owner，Set和Remove不能写到a上
+	pools[1].serving.Add(100)
+	defer pools[1].serving.Add(-100)
+	if _, ok := pools[1].PickPeer(key); !ok {
+		t.Fatalf("overloaded owner should spill %s to a", key)
+	}
+	if _, ok := pools[1].PickOwner(key); ok {
+		t.Fatalf("b should still own %s", key)
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFunc()
+	if err := groups[1].Set(ctx, key, []byte("v2")); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if view, ok := groups[1].mainCache.get(key); !ok || view.String() != "v2" {
+		t.Fatalf("the owner should cache the new value, got %v %v", view, ok)
+	}
+	if _, ok := groups[0].mainCache.get(key); ok {
+		t.Fatalf("a is not the owner and should not cache %s", key)
+	}
+	if err := groups[1].Remove(ctx, key); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if _, ok := groups[1].mainCache.get(key); ok {
+		t.Fatalf("remove should reach the owner")
+	}
+}
@@ -0,0 +1,25 @@
+// Package logging 各个包共用的日志工具
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler 不输出任何日志，Enabled返回false，调用方不会构造日志记录
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+var discardLogger = slog.New(discardHandler{})
+
+// OrDiscard logger为nil时返回不输出任何日志的logger，simpleCache的Group、HTTPPool以及各个Discovery实现默认不输出日志
+func OrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
@@ -0,0 +1,183 @@
+package lfu
+
+import (
+	"container/list"
+	"github.com/thewisecirno/simple_distributed_cache/lru"
+	"time"
+)
+
+// Value 与lru.Value相同，方便不同的淘汰策略互相替换
+type Value = lru.Value
+
+// Cache 最不经常使用淘汰，访问次数相同时淘汰最久没有访问的记录
+// 按访问次数从小到大组织成链表，每个结点内部再用一个链表按访问时间排序，增删改查都是O(1)
+type Cache struct {
+	maxBytes int64
+	nowBytes int64
+
+	//元素为*freqNode，按freq从小到大排列
+	freqs *list.List
+	cache map[string]*list.Element
+
+	//某条记录被删除时的回调函数
+	OnEvicted func(key string, value Value)
+}
+
+type freqNode struct {
+	freq int
+	//元素为*entry，越靠前越新
+	items *list.List
+}
+
+type entry struct {
+	key string
+	val Value
+	//过期时间，零值表示永不过期
+	expire time.Time
+	//所在的freqNode
+	node *list.Element
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func NewCache(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		freqs:     list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes 当前占用的字节数，包括key和value
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
+func (c *Cache) Add(key string, val Value) {
+	c.AddWithExpire(key, val, time.Time{})
+}
+
+// AddWithExpire 添加一条在expireAt之后过期的记录，expireAt为零值时永不过期
+func (c *Cache) AddWithExpire(key string, val Value, expireAt time.Time) {
+	if element, ok := c.cache[key]; ok {
+		kv := element.Value.(*entry)
+		c.nowBytes += int64(val.Len()) - int64(kv.val.Len())
+		kv.val = val
+		kv.expire = expireAt
+		c.increment(element)
+	} else {
+		front := c.freqs.Front()
+		if front == nil || front.Value.(*freqNode).freq != 1 {
+			front = c.freqs.PushFront(&freqNode{freq: 1, items: list.New()})
+		}
+		kv := &entry{key: key, val: val, expire: expireAt, node: front}
+		c.cache[key] = front.Value.(*freqNode).items.PushFront(kv)
+		c.nowBytes += int64(len(key)) + int64(val.Len())
+	}
+	for c.maxBytes != 0 && c.nowBytes > c.maxBytes {
+		c.RemoveOldest()
+	}
+}
+
+// Get 获取key对应的值并增加访问次数，已经过期的记录会在这里被惰性删除
+func (c *Cache) Get(key string) (val Value, ok bool) {
+	if element, ok1 := c.cache[key]; ok1 {
+		kv := element.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(element)
+			return
+		}
+		c.increment(element)
+		return kv.val, ok1
+	}
+	return
+}
+
+// RemoveOldest 淘汰访问次数最少的记录中最久没有访问的一条
+func (c *Cache) RemoveOldest() {
+	if front := c.freqs.Front(); front != nil {
+		c.removeElement(front.Value.(*freqNode).items.Back())
+	}
+}
+
+// RemoveExpired 遍历并删除所有已经过期的记录，返回删除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, element := range c.cache {
+		if element.Value.(*entry).expired(now) {
+			c.removeElement(element)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Remove 删除key对应的记录，不会触发OnEvicted
+func (c *Cache) Remove(key string) {
+	if element, ok := c.cache[key]; ok {
+		c.unlink(element)
+	}
+}
+
+// increment 将记录移动到freq+1的结点中
+func (c *Cache) increment(element *list.Element) {
+	kv := element.Value.(*entry)
+	cur := kv.node
+	next := cur.Next()
+	freq := cur.Value.(*freqNode).freq + 1
+	if next == nil || next.Value.(*freqNode).freq != freq {
+		next = c.freqs.InsertAfter(&freqNode{freq: freq, items: list.New()}, cur)
+	}
+	c.detach(element)
+	kv.node = next
+	c.cache[kv.key] = next.Value.(*freqNode).items.PushFront(kv)
+}
+
+// detach 将记录从所在的freqNode中摘除，freqNode为空时一并删除
+func (c *Cache) detach(element *list.Element) {
+	node := element.Value.(*entry).node
+	items := node.Value.(*freqNode).items
+	items.Remove(element)
+	if items.Len() == 0 {
+		c.freqs.Remove(node)
+	}
+}
+
+func (c *Cache) unlink(element *list.Element) *entry {
+	kv := element.Value.(*entry)
+	c.detach(element)
+	delete(c.cache, kv.key)
+	c.nowBytes -= int64(len(kv.key)) + int64(kv.val.Len())
+	return kv
+}
+
+func (c *Cache) removeElement(element *list.Element) {
+	kv := c.unlink(element)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.val)
+	}
+}
+
+// Hottest 从访问次数最多的记录开始(次数相同时最近访问的在前)，最多为n条没有过期的记录调用fn，不会增加访问次数
+func (c *Cache) Hottest(n int, fn func(key string, val Value, expire time.Time)) {
+	now := time.Now()
+	for node := c.freqs.Back(); node != nil && n > 0; node = node.Prev() {
+		for element := node.Value.(*freqNode).items.Front(); element != nil && n > 0; element = element.Next() {
+			kv := element.Value.(*entry)
+			if kv.expired(now) {
+				continue
+			}
+			fn(kv.key, kv.val, kv.expire)
+			n--
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package lfu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	lfu := NewCache(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestRemoveLeastFrequent(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value Value) {
+		keys = append(keys, key)
+	}
+
+	lfu := NewCache(int64(12), callback)
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Add("k3", String("v3"))
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	//todo k2的访问次数最少，最先被淘汰；k4和k5访问次数相同，更旧的k4接着被淘汰
+	lfu.Add("k4", String("v4"))
+	lfu.Add("k5", String("v5"))
+
+	expect := []string{"k2", "k4"}
+	if !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s, got %s", expect, keys)
+	}
+	if _, ok := lfu.Get("k1"); !ok || lfu.Len() != 3 {
+		t.Fatalf("the most frequent key k1 should survive")
+	}
+}
+
+func TestRemoveAndExpire(t *testing.T) {
+	lfu := NewCache(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	lfu.AddWithExpire("key2", String("5678"), time.Now().Add(-time.Second))
+	lfu.AddWithExpire("key3", String("abcd"), time.Now().Add(-time.Second))
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("expired key2 should be removed lazily")
+	}
+	if removed := lfu.RemoveExpired(); removed != 1 {
+		t.Fatalf("RemoveExpired should remove key3, removed %d", removed)
+	}
+	lfu.Remove("key1")
+	if lfu.Len() != 0 || lfu.nowBytes != 0 || lfu.freqs.Len() != 0 {
+		t.Fatalf("cache should be empty, len %d bytes %d", lfu.Len(), lfu.nowBytes)
+	}
+}
+
+func TestHottest(t *testing.T) {
+	c := NewCache(int64(0), nil)
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+	c.Add("k3", String("v3"))
+	c.Get("k2")
+	c.Get("k2")
+	c.Get("k1")
+	c.Get("k3")
+	//todo k2访问次数最多，k1和k3次数相同时最近访问的k3在前
+	keys := make([]string, 0)
+	c.Hottest(2, func(key string, val Value, expire time.Time) {
+		keys = append(keys, key)
+	})
+	if expect := []string{"k2", "k3"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect hottest keys %s, got %s", expect, keys)
+	}
+}
@@ -0,0 +1,50 @@
+package simpleCache
+
+import (
+	"context"
+	"fmt"
+	"github.com/cespare/xxhash/v2"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+)
+
+// defaultLogSampling 热点路径(缓存命中、选择peer等)默认每100次只输出一次日志
+const defaultLogSampling = 100
+
+// sampler 热点路径的日志采样，每every次返回一次true，every为0时使用defaultLogSampling
+type sampler struct {
+	every uint64
+	n     atomic.Uint64
+}
+
+func (s *sampler) sample() bool {
+	every := s.every
+	if every == 0 {
+		every = defaultLogSampling
+	}
+	return s.n.Add(1)%every == 1 || every == 1
+}
+
+// sampled 日志级别开启了Debug时才计数，关闭时几乎没有开销
+func sampled(ctx context.Context, logger *slog.Logger, s *sampler) bool {
+	return logger.Enabled(ctx, slog.LevelDebug) && s.sample()
+}
+
+// peerAttr HttpGetter和GrpcGetter实现了fmt.Stringer，返回peer的地址
+func peerAttr(peer PeerGetter) slog.Attr {
+	if s, ok := peer.(fmt.Stringer); ok {
+		return slog.String("peer", s.String())
+	}
+	return slog.String("peer", fmt.Sprintf("%T", peer))
+}
+
+// keyHash 日志中只记录key的哈希值，避免输出用户数据，也方便在不同结点的日志中关联同一个key
+func keyHash(key string) slog.Attr {
+	return slog.String("key_hash", hashKey(key))
+}
+
+// hashKey 日志和span中代替key的哈希值
+func hashKey(key string) string {
+	return strconv.FormatUint(xxhash.Sum64String(key), 16)
+}
@@ -0,0 +1,94 @@
+package simpleCache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logRecorder 并发安全地收集JSON格式的日志
+type logRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logRecorder) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logRecorder) records(t *testing.T, msg string) []map[string]interface{} {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(l.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	recorder := &logRecorder{}
+	logger := slog.New(slog.NewJSONHandler(recorder, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	gee := NewGroup("logger-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		if key == "broken" {
+			return nil, errors.New("db is down")
+		}
+		return []byte(db[key]), nil
+	}), WithLogger(logger), WithLogSampling(10))
+
+	for i := 0; i < 101; i++ {
+		if _, err := gee.Get("Tom"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//todo 100次命中每10次输出一次
+	hits := recorder.records(t, "cache hit")
+	if len(hits) != 10 {
+		t.Fatalf("cache hits should be sampled to 10 records, got %d", len(hits))
+	}
+	hit := hits[0]
+	if hit["level"] != "DEBUG" || hit["group"] != "logger-scores" || hit["key_hash"] == nil {
+		t.Fatalf("unexpected record %v", hit)
+	}
+	if strings.Contains(recorder.buf.String(), "Tom") {
+		t.Fatal("raw keys should not be logged")
+	}
+
+	//todo 错误不采样
+	for i := 0; i < 3; i++ {
+		_, _ = gee.Get("broken")
+	}
+	failures := recorder.records(t, "getter failed")
+	if len(failures) != 3 || failures[0]["level"] != "WARN" || failures[0]["err"] != "db is down" || failures[0]["latency"] == nil {
+		t.Fatalf("getter failures should all be logged, got %v", failures)
+	}
+}
+
+func TestLoggerDefault(t *testing.T) {
+	gee := NewGroup("no-logger-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	if gee.logger.Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("default logger should discard everything")
+	}
+	if (&HTTPPool{}).log().Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("nil logger should fall back to the discard logger")
+	}
+}
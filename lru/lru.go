@@ -1,6 +1,9 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 
 type Cache struct {
 	maxBytes int64
@@ -16,6 +19,12 @@ type Cache struct {
 type entry struct {
 	key string
 	val Value
+	//过期时间，零值表示永不过期
+	expire time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
 }
 
 type Value interface {
@@ -27,6 +36,11 @@ func (c *Cache) Len() int {
 	return c.ll.Len()
 }
 
+// Bytes 当前占用的字节数，包括key和value
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
 func NewCache(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
@@ -38,13 +52,19 @@ func NewCache(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
 }
 
 func (c *Cache) Add(key string, val Value) {
+	c.AddWithExpire(key, val, time.Time{})
+}
+
+// AddWithExpire 添加一条在expireAt之后过期的记录，expireAt为零值时永不过期
+func (c *Cache) AddWithExpire(key string, val Value, expireAt time.Time) {
 	if element, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(element)
 		kv := element.Value.(*entry)
 		c.nowBytes += int64(val.Len()) - int64(kv.val.Len())
 		kv.val = val
+		kv.expire = expireAt
 	} else {
-		front := c.ll.PushFront(&entry{key, val})
+		front := c.ll.PushFront(&entry{key, val, expireAt})
 		c.cache[key] = front
 		c.nowBytes += int64(len(key)) + int64(val.Len())
 	}
@@ -53,10 +73,15 @@ func (c *Cache) Add(key string, val Value) {
 	}
 }
 
+// Get 获取key对应的值，已经过期的记录会在这里被惰性删除
 func (c *Cache) Get(key string) (val Value, ok bool) {
 	if element, ok1 := c.cache[key]; ok1 {
-		c.ll.MoveToFront(element)
 		kv := element.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(element)
+			return
+		}
+		c.ll.MoveToFront(element)
 		return kv.val, ok1
 	}
 	return
@@ -65,12 +90,54 @@ func (c *Cache) Get(key string) (val Value, ok bool) {
 func (c *Cache) RemoveOldest() {
 	oldest := c.ll.Back()
 	if oldest != nil {
-		c.ll.Remove(oldest)
-		kv := oldest.Value.(*entry)
+		c.removeElement(oldest)
+	}
+}
+
+// RemoveExpired 遍历并删除所有已经过期的记录，返回删除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for element := c.ll.Back(); element != nil; {
+		prev := element.Prev()
+		if element.Value.(*entry).expired(now) {
+			c.removeElement(element)
+			removed++
+		}
+		element = prev
+	}
+	return removed
+}
+
+func (c *Cache) removeElement(element *list.Element) {
+	c.ll.Remove(element)
+	kv := element.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nowBytes -= int64(len(kv.key)) + int64(kv.val.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.val)
+	}
+}
+
+// Remove 删除key对应的记录，不会触发OnEvicted
+func (c *Cache) Remove(key string) {
+	if element, ok := c.cache[key]; ok {
+		c.ll.Remove(element)
+		kv := element.Value.(*entry)
 		delete(c.cache, kv.key)
 		c.nowBytes -= int64(len(kv.key)) + int64(kv.val.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.val)
+	}
+}
+
+// Hottest 从最近访问的记录开始，最多为n条没有过期的记录调用fn，不会改变记录的顺序
+func (c *Cache) Hottest(n int, fn func(key string, val Value, expire time.Time)) {
+	now := time.Now()
+	for element := c.ll.Front(); element != nil && n > 0; element = element.Next() {
+		kv := element.Value.(*entry)
+		if kv.expired(now) {
+			continue
 		}
+		fn(kv.key, kv.val, kv.expire)
+		n--
 	}
 }
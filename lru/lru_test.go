@@ -5,6 +5,7 @@ import (
 	"log"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -59,3 +60,60 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestRemove(t *testing.T) {
+	lru := NewCache(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+	lru.Remove("key1")
+	lru.Remove("key3")
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("Remove key1 failed")
+	}
+	if lru.nowBytes != int64(len("key2")+len("5678")) {
+		t.Fatalf("nowBytes should be %d after remove, got %d", len("key2")+len("5678"), lru.nowBytes)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	evicted := 0
+	lru := NewCache(int64(0), func(key string, value Value) {
+		evicted++
+	})
+	lru.AddWithExpire("key1", String("1234"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(time.Hour))
+	lru.Add("key3", String("abcd"))
+
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 2 || evicted != 1 {
+		t.Fatalf("expired key1 should be removed lazily")
+	}
+	if v, ok := lru.Get("key2"); !ok || string(v.(String)) != "5678" {
+		t.Fatalf("cache hit key2=5678 failed")
+	}
+
+	lru.AddWithExpire("key2", String("5678"), time.Now().Add(-time.Second))
+	lru.AddWithExpire("key4", String("efgh"), time.Now().Add(-time.Second))
+	if removed := lru.RemoveExpired(); removed != 2 || lru.Len() != 1 || evicted != 3 {
+		t.Fatalf("RemoveExpired should remove key2 and key4, removed %d", removed)
+	}
+	if lru.nowBytes != int64(len("key3")+len("abcd")) {
+		t.Fatalf("nowBytes should be %d after RemoveExpired, got %d", len("key3")+len("abcd"), lru.nowBytes)
+	}
+}
+
+func TestHottest(t *testing.T) {
+	c := NewCache(int64(0), nil)
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+	c.AddWithExpire("k3", String("v3"), time.Now().Add(-time.Second))
+	c.Add("k4", String("v4"))
+	c.Get("k1")
+	//todo 过期的k3被跳过
+	keys := make([]string, 0)
+	c.Hottest(2, func(key string, val Value, expire time.Time) {
+		keys = append(keys, key)
+	})
+	if expect := []string{"k1", "k4"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect hottest keys %s, got %s", expect, keys)
+	}
+}
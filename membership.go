@@ -0,0 +1,259 @@
+package simpleCache
+
+import (
+	"github.com/thewisecirno/simple_distributed_cache/consistentHash"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"github.com/thewisecirno/simple_distributed_cache/internal/logging"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// membership 成员表，维护 注册key -> 地址 -> getter 的映射，并增量地更新哈希环
+// 同一个地址可能同时挂在多个key下(例如租约丢失后以新的key重新注册)，只有最后一个key被删除时才会把地址移出哈希环
+// 删除事件只需要带上key，即使etcd没有返回删除前的值也能找到对应的地址
+type membership struct {
+	self string
+
+	mu    sync.Mutex
+	peers consistentHash.Placement
+	//注册key -> 地址
+	keys map[string]string
+	//地址 -> 引用它的key的数量
+	refs    map[string]int
+	getters map[string]PeerGetter
+	//为新加入的地址创建getter，self不会创建getter
+	newGetter func(addr string) (PeerGetter, error)
+
+	//大于0时开启bounded load，每个结点的负载不超过平均负载的loadFactor倍
+	loadFactor float64
+	//self当前的负载，其他结点的负载由getter的Load提供
+	selfLoad func() int64
+
+	logger *slog.Logger
+}
+
+// loader 能报告当前负载(正在进行的请求数)的getter
+type loader interface {
+	Load() int64
+}
+
+func newMembership(self string, newGetter func(addr string) (PeerGetter, error)) *membership {
+	return &membership{
+		self:      self,
+		peers:     consistentHash.NewConsistentHash(consistentHash.DefaultReplicas, nil),
+		keys:      make(map[string]string),
+		refs:      make(map[string]int),
+		getters:   make(map[string]PeerGetter),
+		newGetter: newGetter,
+		logger:    logging.OrDiscard(nil),
+	}
+}
+
+// apply 将服务发现的事件应用到成员表，返回哈希环是否发生了变化
+func (m *membership) apply(event discovery.Event) bool {
+	switch event.Type {
+	case discovery.Add:
+		weight := event.Peer.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		return m.add(event.Key, event.Peer.Addr, weight)
+	case discovery.Remove:
+		return m.remove(event.Key)
+	}
+	return false
+}
+
+// add 记录key -> addr，addr第一次出现时加入哈希环并创建getter
+// key原来指向别的地址时，相当于先删除旧的映射；weight与当前不同时修改权重，为0时保持原来的权重(新结点为1)
+func (m *membership) add(key, addr string, weight int) bool {
+	if addr == "" {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	if old, ok := m.keys[key]; ok && old != addr {
+		delete(m.keys, key)
+		changed = m.unref(old)
+	}
+
+	if _, ok := m.keys[key]; !ok {
+		if m.refs[addr] == 0 && addr != m.self {
+			if _, ok = m.getters[addr]; !ok {
+				getter, err := m.newGetter(addr)
+				if err != nil {
+					//todo 创建失败时不加入哈希环，否则会选到一个没有getter的peer
+					m.logger.Warn("create getter failed", "peer", addr, "err", err)
+					return changed
+				}
+				m.getters[addr] = getter
+			}
+		}
+		m.keys[key] = addr
+		m.refs[addr]++
+	}
+
+	if m.refs[addr] == 1 && m.peers.Weight(addr) == 0 {
+		m.peers.AddWeighted(addr, weight)
+		return true
+	}
+	if weight > 0 && m.peers.Weight(addr) != weight {
+		m.peers.AddWeighted(addr, weight)
+		return true
+	}
+	return changed
+}
+
+// setWeight 修改addr的权重，addr不在哈希环上时返回false
+func (m *membership) setWeight(addr string, weight int) bool {
+	if weight < 1 {
+		weight = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.refs[addr] == 0 {
+		return false
+	}
+	if m.peers.Weight(addr) != weight {
+		m.peers.AddWeighted(addr, weight)
+	}
+	return true
+}
+
+// remove 删除key的映射，地址不再被任何key引用时移出哈希环并关闭getter
+// self始终留在自己的哈希环上：Shutdown时Deregister产生的删除事件、租约过期等都可能报告self被删除
+func (m *membership) remove(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addr, ok := m.keys[key]
+	if !ok {
+		return false
+	}
+	delete(m.keys, key)
+	return m.unref(addr)
+}
+
+// unref 需要持有mu，self的最后一个引用不会被删除
+func (m *membership) unref(addr string) bool {
+	if addr == m.self && m.refs[addr] <= 1 {
+		m.logger.Warn("ignore removal of self from its own ring", "peer", addr)
+		return false
+	}
+	m.refs[addr]--
+	if m.refs[addr] > 0 {
+		return false
+	}
+	delete(m.refs, addr)
+	m.peers.Del(addr)
+	if getter, ok := m.getters[addr]; ok {
+		delete(m.getters, addr)
+		m.closeGetter(addr, getter)
+	}
+	return true
+}
+
+func (m *membership) pick(key string) (string, PeerGetter, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	get := ""
+	if m.loadFactor > 0 {
+		get = m.peers.GetBounded(key, m.loadFactor, m.load)
+	} else {
+		get = m.peers.Get(key)
+	}
+	if get != "" && get != m.self {
+		return get, m.getters[get], true
+	}
+	return "", nil, false
+}
+
+// owner 返回哈希环上负责key的getter，不受loadFactor影响
+func (m *membership) owner(key string) (string, PeerGetter, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if get := m.peers.Get(key); get != "" && get != m.self {
+		return get, m.getters[get], true
+	}
+	return "", nil, false
+}
+
+// pickN 返回key的前n个owner中排在self之前的getter
+func (m *membership) pickN(key string, n int) []PeerGetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	getters := make([]PeerGetter, 0, n)
+	for _, addr := range m.peers.GetN(key, n) {
+		if addr == m.self {
+			break
+		}
+		getters = append(getters, m.getters[addr])
+	}
+	return getters
+}
+
+// successor 返回self离开哈希环后负责key的peer，用于Shutdown时把热点key交给新的owner
+func (m *membership) successor(key string) (PeerGetter, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, addr := range m.peers.GetN(key, 2) {
+		if addr != m.self {
+			return m.getters[addr], true
+		}
+	}
+	return nil, false
+}
+
+// load 需要持有mu
+func (m *membership) load(addr string) int64 {
+	if addr == m.self {
+		if m.selfLoad != nil {
+			return m.selfLoad()
+		}
+		return 0
+	}
+	if l, ok := m.getters[addr].(loader); ok {
+		return l.Load()
+	}
+	return 0
+}
+
+// all 返回除self外所有peer的getter
+func (m *membership) all() []PeerGetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	getters := make([]PeerGetter, 0, len(m.getters))
+	for _, getter := range m.getters {
+		getters = append(getters, getter)
+	}
+	return getters
+}
+
+// addrs 返回哈希环中的所有地址(包括self)
+func (m *membership) addrs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	addrs := make([]string, 0, len(m.refs))
+	for addr := range m.refs {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// close 关闭所有getter
+func (m *membership) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, getter := range m.getters {
+		m.closeGetter(addr, getter)
+	}
+}
+
+func (m *membership) closeGetter(addr string, getter PeerGetter) {
+	if closer, ok := getter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			m.logger.Warn("close peer failed", "peer", addr, "err", err)
+		}
+	}
+}
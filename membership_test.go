@@ -0,0 +1,206 @@
+package simpleCache
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// chanDiscovery 由测试直接推送事件，模拟etcd中结点的加入和离开
+type chanDiscovery struct {
+	events chan discovery.Event
+}
+
+func (d *chanDiscovery) Register(ctx context.Context, self discovery.Peer) error {
+	return nil
+}
+
+func (d *chanDiscovery) Deregister(ctx context.Context) error {
+	return nil
+}
+
+func (d *chanDiscovery) Watch(ctx context.Context) (<-chan discovery.Event, error) {
+	return d.events, nil
+}
+
+type closingPeer struct {
+	fakePeer
+	closed bool
+}
+
+func (c *closingPeer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestMembership(t *testing.T) {
+	getters := make(map[string]*closingPeer)
+	m := newMembership("self", func(addr string) (PeerGetter, error) {
+		getters[addr] = &closingPeer{}
+		return getters[addr], nil
+	})
+	m.add("self", "self", 0)
+	if !m.add("k1", "a", 0) || !m.add("k2", "b", 0) {
+		t.Fatal("new addresses should change the ring")
+	}
+	if len(m.all()) != 2 || len(getters) != 2 {
+		t.Fatalf("self should not get a getter, got %d getters", len(m.all()))
+	}
+
+	//todo 同一个地址以新的key重新注册，删除旧key后地址依然在环上
+	if m.add("k3", "a", 0) {
+		t.Fatal("a is already in the ring")
+	}
+	if m.remove("k1") || getters["a"].closed {
+		t.Fatal("a is still registered under k3")
+	}
+	if !m.remove("k3") || !getters["a"].closed {
+		t.Fatal("a should be removed and closed after its last key is deleted")
+	}
+	if m.remove("unknown") {
+		t.Fatal("removing an unknown key should be a no-op")
+	}
+
+	//todo key指向了新的地址
+	if !m.add("k2", "c", 0) || !getters["b"].closed {
+		t.Fatal("b should be replaced by c")
+	}
+	sorted := m.addrs()
+	sort.Strings(sorted)
+	if fmt.Sprint(sorted) != "[c self]" {
+		t.Fatalf("unexpected ring %v", sorted)
+	}
+
+	//todo 服务发现报告self被删除(例如以自己的地址为key注册，或者租约过期)，self依然留在自己的哈希环上
+	if m.add("lease", "self", 0) || m.remove("lease") || m.remove("self") || m.peers.Weight("self") == 0 {
+		t.Fatal("self should stay in its own ring")
+	}
+
+	//todo 相同key的Add事件带上新的权重，Set不会覆盖已有的权重
+	if !m.apply(discovery.Event{Type: discovery.Add, Key: "k2", Peer: discovery.Peer{Addr: "c", Weight: 3}}) || m.peers.Weight("c") != 3 {
+		t.Fatalf("c should have weight 3, got %d", m.peers.Weight("c"))
+	}
+	if m.add("c", "c", 0) || m.peers.Weight("c") != 3 {
+		t.Fatal("adding c without a weight should keep its weight")
+	}
+	if !m.setWeight("c", 1) || m.peers.Weight("c") != 1 || m.setWeight("unknown", 2) {
+		t.Fatal("setWeight should only change peers in the ring")
+	}
+}
+
+// pickedAddrs 返回100个key分别被分配到的结点
+func pickedAddrs(pool *HTTPPool) map[string]int {
+	picked := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		if getter, ok := pool.PickPeer(fmt.Sprintf("key%d", i)); ok {
+			picked[getter.(*HttpGetter).baseURL]++
+		} else {
+			picked[pool.self]++
+		}
+	}
+	return picked
+}
+
+func waitPicked(t *testing.T, pool *HTTPPool, want ...string) map[string]int {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		picked := pickedAddrs(pool)
+		match := len(picked) == len(want)
+		for _, addr := range want {
+			match = match && picked[addr] > 0
+		}
+		if match {
+			return picked
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect keys to be spread over %v, got %v", want, picked)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPPoolJoinLeave(t *testing.T) {
+	NewGroup("membership", 2<<10, GetterHandler(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	a := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer a.Close()
+	b := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer b.Close()
+	addrA, addrB := a.Listener.Addr().String(), b.Listener.Addr().String()
+	urlA, urlB := addrA+defaultBasePath, addrB+defaultBasePath
+
+	defer func() {
+		resetPool()
+	}()
+	d := &chanDiscovery{events: make(chan discovery.Event, 10)}
+	defer close(d.events)
+	pool := NewHTTPPool("self", "", d)
+
+	d.events <- discovery.Event{Type: discovery.Add, Key: "Cache&1", Peer: discovery.Peer{Addr: addrA}}
+	d.events <- discovery.Event{Type: discovery.Add, Key: "Cache&2", Peer: discovery.Peer{Addr: addrB}}
+	waitPicked(t, pool, "self", urlA, urlB)
+
+	//todo 选到的getter能访问对应的结点
+	getter, ok := pool.PickPeer("key1")
+	if ok {
+		res := &pb.Response{}
+		if err := getter.Get(context.Background(), &pb.Request{Group: "membership", Key: "key1"}, res); err != nil || string(res.Value) != "key1" {
+			t.Fatalf("get key1 from %s failed: %v", getter.(*HttpGetter).baseURL, err)
+		}
+	}
+
+	//todo a的租约丢失后以新key重新注册，旧key的删除事件不能把a移出环
+	d.events <- discovery.Event{Type: discovery.Add, Key: "Cache&3", Peer: discovery.Peer{Addr: addrA}}
+	d.events <- discovery.Event{Type: discovery.Remove, Key: "Cache&1"}
+	time.Sleep(50 * time.Millisecond)
+	waitPicked(t, pool, "self", urlA, urlB)
+
+	//todo 删除事件没有带上地址，也能通过key找到结点
+	d.events <- discovery.Event{Type: discovery.Remove, Key: "Cache&2"}
+	picked := waitPicked(t, pool, "self", urlA)
+	if len(pool.GetAll()) != 1 {
+		t.Fatalf("b should be removed, picked %v", picked)
+	}
+
+	d.events <- discovery.Event{Type: discovery.Remove, Key: "Cache&3", Peer: discovery.Peer{Addr: addrA}}
+	waitPicked(t, pool, "self")
+	if len(pool.GetAll()) != 0 {
+		t.Fatal("all peers left, only self should remain")
+	}
+}
+
+func TestHTTPPoolPickReplicas(t *testing.T) {
+	defer func() {
+		resetPool()
+	}()
+	pool := NewHTTPPool("self", "", nil)
+	pool.Set("a", "b", "c")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owners := pool.members.peers.GetN(key, 3)
+		replicas := pool.PickReplicas(key, 3)
+		//todo 只返回排在self之前的owner
+		for j, owner := range owners {
+			if owner == "self" {
+				if len(replicas) != j {
+					t.Fatalf("%s: owners %v, expect %d replicas before self, got %d", key, owners, j, len(replicas))
+				}
+				break
+			}
+			if replicas[j].(*HttpGetter).baseURL != owner+defaultBasePath {
+				t.Fatalf("%s: replica %d should be %s", key, j, owner)
+			}
+		}
+		if primary, ok := pool.PickPeer(key); ok != (len(replicas) > 0) || ok && primary != replicas[0] {
+			t.Fatalf("%s: the first replica should be the primary owner", key)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package simpleCache
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const (
+	metricsNamespace = "simple_cache"
+	// defaultMetricsPath HTTPPool在这个路径上提供Prometheus指标
+	defaultMetricsPath = "/metrics"
+)
+
+// Registry defaultNode的指标都注册在这里，可以注册自己的指标一起暴露
+var Registry = prometheus.NewRegistry()
+
+// newPeerRequestDuration 请求peer的耗时，每个Node有自己的直方图，只记录这个Node发出的请求
+func newPeerRequestDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "peer_request_duration_seconds",
+		Help:      "Latency of requests sent to other peers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"peer", "method", "result"})
+}
+
+func init() {
+	Registry.MustRegister(
+		groupCollector{node: defaultNode},
+		defaultNode.peerRequests,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// MetricsHandler 返回/metrics的handler，HTTPPool已经在defaultMetricsPath上提供，只使用gRPC时可以自己注册
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// observePeerRequest 把一次对peer的请求的耗时记录到requests中，result为ok、not_found或error
+// requests为nil时(例如不是通过HTTPPool或GRPCPool创建的getter)记录到defaultNode中
+func observePeerRequest(requests *prometheus.HistogramVec, peer, method string, start time.Time, err error) {
+	if requests == nil {
+		requests = defaultNode.peerRequests
+	}
+	result := "ok"
+	if errors.Is(err, ErrNotFound) {
+		result = "not_found"
+	} else if err != nil {
+		result = "error"
+	}
+	requests.WithLabelValues(peer, method, result).Observe(time.Since(start).Seconds())
+}
+
+type groupCounter struct {
+	desc  *prometheus.Desc
+	value func(s *Stats) int64
+}
+
+func newGroupCounter(name, help string, value func(s *Stats) int64) groupCounter {
+	return groupCounter{
+		desc:  prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, []string{"group"}, nil),
+		value: value,
+	}
+}
+
+// groupCounters 与Stats中的字段一一对应
+var groupCounters = []groupCounter{
+	newGroupCounter("gets_total", "Get requests.", func(s *Stats) int64 { return s.Gets.Load() }),
+	newGroupCounter("cache_hits_total", "Hits in the main cache.", func(s *Stats) int64 { return s.CacheHits.Load() }),
+	newGroupCounter("hot_cache_hits_total", "Hits in the hot cache.", func(s *Stats) int64 { return s.HotCacheHits.Load() }),
+	newGroupCounter("negative_hits_total", "Hits in the negative cache.", func(s *Stats) int64 { return s.NegativeHits.Load() }),
+	newGroupCounter("stale_hits_total", "Expired values served while revalidating.", func(s *Stats) int64 { return s.StaleHits.Load() }),
+	newGroupCounter("stale_errors_total", "Expired values served because loading failed.", func(s *Stats) int64 { return s.StaleErrors.Load() }),
+	newGroupCounter("refreshes_total", "Background refreshes.", func(s *Stats) int64 { return s.Refreshes.Load() }),
+	newGroupCounter("refresh_errors_total", "Failed background refreshes.", func(s *Stats) int64 { return s.RefreshErrors.Load() }),
+	newGroupCounter("peer_loads_total", "Values loaded from peers.", func(s *Stats) int64 { return s.PeerLoads.Load() }),
+	newGroupCounter("peer_errors_total", "Failed loads from peers.", func(s *Stats) int64 { return s.PeerErrors.Load() }),
+	newGroupCounter("replica_loads_total", "Values loaded from a replica after the primary owner failed.", func(s *Stats) int64 { return s.ReplicaLoads.Load() }),
+	newGroupCounter("loads_deduped_total", "Loads that waited for a concurrent load of the same key.", func(s *Stats) int64 { return s.LoadsDeduped.Load() }),
+	newGroupCounter("local_loads_total", "Calls to the local getter.", func(s *Stats) int64 { return s.LocalLoads.Load() }),
+	newGroupCounter("batch_loads_total", "Batch requests sent by GetMulti.", func(s *Stats) int64 { return s.BatchLoads.Load() }),
+	newGroupCounter("bloom_rejects_total", "Keys rejected by the Bloom filter.", func(s *Stats) int64 { return s.BloomRejects.Load() }),
+	newGroupCounter("bloom_false_positives_total", "Missing keys that passed the Bloom filter.", func(s *Stats) int64 { return s.BloomFalsePositives.Load() }),
+	newGroupCounter("invalidate_errors_total", "Failed requests asking peers to drop hot copies.", func(s *Stats) int64 { return s.InvalidateErrors.Load() }),
+}
+
+var (
+	cacheBytesDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "cache_bytes"),
+		"Bytes used by keys and values.", []string{"group", "cache"}, nil)
+	cacheItemsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "cache_items"),
+		"Number of entries.", []string{"group", "cache"}, nil)
+	cacheEvictionsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "cache_evictions_total"),
+		"Entries evicted for space or expiration.", []string{"group", "cache"}, nil)
+	bloomFPRateDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "bloom_estimated_fp_rate"),
+		"False positive rate of the Bloom filter estimated from its number of keys.", []string{"group"}, nil)
+	bloomFillDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "bloom_fill_ratio"),
+		"Fraction of bits set in the Bloom filter.", []string{"group"}, nil)
+)
+
+// groupCollector 每次抓取时读取node中所有Group的Stats和缓存大小，不需要在请求路径上额外记录
+type groupCollector struct {
+	node *Node
+}
+
+func (groupCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, counter := range groupCounters {
+		ch <- counter.desc
+	}
+	ch <- cacheBytesDesc
+	ch <- cacheItemsDesc
+	ch <- cacheEvictionsDesc
+	ch <- bloomFPRateDesc
+	ch <- bloomFillDesc
+}
+
+func (collector groupCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, group := range collector.node.Groups() {
+		for _, counter := range groupCounters {
+			ch <- prometheus.MustNewConstMetric(counter.desc, prometheus.CounterValue, float64(counter.value(&group.Stats)), group.name)
+		}
+		caches := map[string]*shardedCache{"main": group.mainCache, "hot": group.hotCache, "negative": group.negativeCache}
+		for name, c := range caches {
+			ch <- prometheus.MustNewConstMetric(cacheBytesDesc, prometheus.GaugeValue, float64(c.bytes()), group.name, name)
+			ch <- prometheus.MustNewConstMetric(cacheItemsDesc, prometheus.GaugeValue, float64(c.len()), group.name, name)
+			ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(c.evictions()), group.name, name)
+		}
+		//todo 只有开启了Bloom filter的Group有这两个指标
+		if group.bloom != nil {
+			ch <- prometheus.MustNewConstMetric(bloomFPRateDesc, prometheus.GaugeValue, group.bloom.estimatedFPRate(), group.name)
+			ch <- prometheus.MustNewConstMetric(bloomFillDesc, prometheus.GaugeValue, group.bloom.fillRatio(), group.name)
+		}
+	}
+}
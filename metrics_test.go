@@ -0,0 +1,200 @@
+package simpleCache
+
+import (
+	"context"
+	"fmt"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, url string) string {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("scrape returned %s: %s", res.Status, body)
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	release := make(chan struct{})
+	gee := NewGroup("metrics-scores", 1<<10, GetterHandler(func(key string) ([]byte, error) {
+		if key == "slow" {
+			<-release
+		}
+		return []byte(strings.Repeat("v", 100)), nil
+	}), WithHotCache(0), WithNegativeTTL(0), WithShards(1))
+
+	//todo 5个并发请求只有一个调用getter
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = gee.Get("slow")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if gee.Stats.LoadsDeduped.Load() != 4 || gee.Stats.LocalLoads.Load() != 1 {
+		t.Fatalf("loads deduped %d, local loads %d", gee.Stats.LoadsDeduped.Load(), gee.Stats.LocalLoads.Load())
+	}
+	//todo 1KB只能放下9条记录
+	for i := 0; i < 20; i++ {
+		_, _ = gee.Get(fmt.Sprintf("key%d", i))
+	}
+
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	getter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+	if err := getter.Get(context.Background(), &pb.Request{Group: "metrics-scores", Key: "key19"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := scrape(t, server.URL+defaultMetricsPath)
+	for _, want := range []string{
+		`simple_cache_gets_total{group="metrics-scores"} 26`,
+		`simple_cache_cache_hits_total{group="metrics-scores"} 1`,
+		`simple_cache_loads_deduped_total{group="metrics-scores"} 4`,
+		`simple_cache_local_loads_total{group="metrics-scores"} 21`,
+		`simple_cache_cache_items{cache="main",group="metrics-scores"} 9`,
+		`simple_cache_cache_evictions_total{cache="main",group="metrics-scores"} 12`,
+		fmt.Sprintf(`simple_cache_peer_request_duration_seconds_count{method="get",peer="%s",result="ok"} 1`, getter.baseURL),
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics should contain %s, got\n%s", want, body)
+		}
+	}
+	if !strings.Contains(body, `simple_cache_cache_bytes{cache="main",group="metrics-scores"}`) {
+		t.Fatal("metrics should contain cache_bytes")
+	}
+}
+
+func TestNodeMetrics(t *testing.T) {
+	node := NewNode()
+	defer node.Close()
+	node.NewGroup("node-metrics-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	NewGroup("default-metrics-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+
+	//todo 每个Node的/metrics只包含自己的Group
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath, node: node})
+	defer server.Close()
+	body := scrape(t, server.URL+defaultMetricsPath)
+	if !strings.Contains(body, `group="node-metrics-scores"`) || strings.Contains(body, `group="default-metrics-scores"`) {
+		t.Fatalf("node metrics should only contain its own groups:\n%s", body)
+	}
+	if !strings.Contains(body, "go_goroutines") {
+		t.Fatal("node metrics should contain runtime metrics")
+	}
+}
+
+func TestNodePeerMetrics(t *testing.T) {
+	node := NewNode()
+	defer node.Close()
+	node.NewGroup("node-peer-metrics", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath, node: node})
+	defer server.Close()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := newGRPCPool(node, lis.Addr().String())
+	go func() {
+		_ = pool.Serve(lis)
+	}()
+	defer pool.Stop()
+
+	//todo 两种getter的每种请求都记录到所属Node的直方图中
+	httpGetter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath, requests: node.peerRequests}
+	grpcGetter, err := NewGrpcGetter(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer grpcGetter.Close()
+	grpcGetter.requests = node.peerRequests
+	ctx := context.Background()
+	for _, getter := range []interface {
+		PeerGetter
+		BatchPeerGetter
+	}{httpGetter, grpcGetter} {
+		if err := getter.Set(ctx, &pb.SetRequest{Group: "node-peer-metrics", Key: "Tom", Value: []byte("630")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := getter.Get(ctx, &pb.Request{Group: "node-peer-metrics", Key: "Tom"}, &pb.Response{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := getter.GetMulti(ctx, &pb.GetMultiRequest{Group: "node-peer-metrics", Keys: []string{"Tom"}}, &pb.GetMultiResponse{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := getter.Remove(ctx, &pb.Request{Group: "node-peer-metrics", Key: "Tom"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := scrape(t, server.URL+defaultMetricsPath)
+	for _, peer := range []string{httpGetter.baseURL, grpcGetter.addr} {
+		for _, method := range []string{"get", "get_multi", "set", "remove"} {
+			want := fmt.Sprintf(`simple_cache_peer_request_duration_seconds_count{method="%s",peer="%s",result="ok"} 1`, method, peer)
+			if !strings.Contains(body, want) {
+				t.Fatalf("node metrics should contain %s, got\n%s", want, body)
+			}
+		}
+	}
+	//todo 其他Node的请求不会出现在defaultNode的指标中
+	defaultServer := httptest.NewServer(MetricsHandler())
+	defer defaultServer.Close()
+	if body := scrape(t, defaultServer.URL); strings.Contains(body, httpGetter.baseURL) || strings.Contains(body, grpcGetter.addr) {
+		t.Fatalf("default metrics should not contain requests of another node:\n%s", body)
+	}
+}
+
+func TestBloomMetrics(t *testing.T) {
+	node := NewNode()
+	defer node.Close()
+	gee := node.NewGroup("bloom-metrics", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}), WithBloomFilter(BloomConfig{Keys: func(ctx context.Context, add func(key string)) error {
+		for i := 0; i < 100; i++ {
+			add(fmt.Sprintf("key%d", i))
+		}
+		return nil
+	}, ExpectedKeys: 100}))
+	node.NewGroup("no-bloom-metrics", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	waitBloom(t, gee)
+
+	server := httptest.NewServer(node.MetricsHandler())
+	defer server.Close()
+	body := scrape(t, server.URL)
+	for _, name := range []string{"simple_cache_bloom_estimated_fp_rate", "simple_cache_bloom_fill_ratio"} {
+		if !strings.Contains(body, name+`{group="bloom-metrics"} 0.`) {
+			t.Fatalf("metrics should contain %s of bloom-metrics, got\n%s", name, body)
+		}
+		if strings.Contains(body, name+`{group="no-bloom-metrics"}`) {
+			t.Fatalf("group without a Bloom filter should not export %s", name)
+		}
+	}
+}
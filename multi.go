@@ -0,0 +1,327 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"github.com/thewisecirno/simple_distributed_cache/singleFlight"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"sync"
+)
+
+// BatchGetter 可选接口，GetMulti一次从数据源获取多个key，返回的map中没有的key按ErrNotFound处理，返回错误时所有key都失败
+type BatchGetter interface {
+	Getter
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+type BatchGetterHandler func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+func (f BatchGetterHandler) Get(key string) ([]byte, error) {
+	values, err := f(context.Background(), []string{key})
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := values[key]; ok {
+		return value, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (f BatchGetterHandler) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return f(ctx, keys)
+}
+
+// Result GetMulti中单个key的结果，与Get的返回值相同
+type Result struct {
+	View *ByteView
+	Err  error
+}
+
+// GetMulti 获取多个key，返回每个key各自的结果，重复的key只获取一次
+// 缓存未命中的key按owner分组，每个owner只发送一次GetMulti(peer需要实现BatchPeerGetter，否则逐个Get)，
+// owner失败的key像Get一样依次尝试其他owner(WithReplicas)，都失败的key和自己负责的key一起交给本地getter，getter实现了BatchGetter时只调用一次
+// 每个key都经过与Get相同的singleFlight，来自其他peer的请求不再按owner分组，全部在本地加载
+func (g *Group) GetMulti(ctx context.Context, keys []string) map[string]Result {
+	ctx, span := startSpan(ctx, "Group.GetMulti", trace.WithAttributes(
+		attribute.String("cache.group", g.name), attribute.Int("cache.keys", len(keys))))
+	defer span.End()
+	results := make(map[string]Result, len(keys))
+	stale := make(map[string]*ByteView)
+	var (
+		local  []string
+		remote []string
+		owners = make(map[string][]PeerGetter)
+		misses []string
+	)
+	for _, key := range keys {
+		if _, ok := results[key]; ok {
+			continue
+		}
+		if key == "" {
+			results[key] = Result{View: &ByteView{}, Err: errors.New("key is required")}
+			continue
+		}
+		g.Stats.Gets.Add(1)
+		view, staleView, done, err := g.lookup(ctx, key, nil)
+		if done {
+			results[key] = Result{View: view, Err: err}
+			continue
+		}
+		//todo 占位，防止重复的key被加载两次
+		results[key] = Result{}
+		misses = append(misses, key)
+		if staleView != nil {
+			stale[key] = staleView
+		}
+		if _, peers := g.flight(ctx, key); len(peers) > 0 {
+			remote = append(remote, key)
+			owners[key] = peers
+			continue
+		}
+		local = append(local, key)
+	}
+	if len(misses) == 0 {
+		return results
+	}
+	//todo 与Get一样，最多等待loadTimeout
+	ctx, cancelFunc := context.WithTimeout(ctx, g.loadTimeout)
+	defer cancelFunc()
+
+	var mu sync.Mutex
+	set := func(key string, view *ByteView, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[key] = Result{View: view, Err: err}
+	}
+	if len(remote) > 0 {
+		local = append(local, g.getMultiRemote(ctx, remote, owners, set)...)
+	}
+	if len(local) > 0 {
+		g.getMultiLocally(ctx, local, set)
+	}
+
+	for _, key := range misses {
+		result := results[key]
+		g.bloomLoaded(key, result.Err)
+		if result.Err != nil && g.serveStale(key, stale[key], result.Err) {
+			results[key] = Result{View: stale[key]}
+		}
+	}
+	return results
+}
+
+// errOwnersFailed 转发的加载中所有owner都失败了，调用者改为在本地加载(与其他本地加载的key合并成一次BatchGetter调用)
+var errOwnersFailed = errors.New("all owners failed")
+
+// getMultiRemote 从owners获取keys，与同时进行的Get共用转发使用的singleFlight key，
+// 每一轮把还没有结果的key按当前尝试的owner分组，失败的key在下一轮尝试下一个owner，返回所有owner都失败、需要在本地加载的key
+func (g *Group) getMultiRemote(ctx context.Context, keys []string, owners map[string][]PeerGetter, set func(key string, view *ByteView, err error)) (failed []string) {
+	flights := make([]string, 0, len(keys))
+	for _, key := range keys {
+		flights = append(flights, forwardFlight+key)
+	}
+	results := g.single.DoMulti(ctx, flights, func(loadCtx context.Context, flights []string) map[string]singleFlight.Result {
+		var mu sync.Mutex
+		results := make(map[string]singleFlight.Result, len(flights))
+		pending := make([]string, 0, len(flights))
+		for _, flight := range flights {
+			pending = append(pending, strings.TrimPrefix(flight, forwardFlight))
+		}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			byPeer := make(map[PeerGetter][]string)
+			for _, key := range pending {
+				if attempt < len(owners[key]) {
+					peer := owners[key][attempt]
+					byPeer[peer] = append(byPeer[peer], key)
+					continue
+				}
+				results[forwardFlight+key] = singleFlight.Result{Err: errOwnersFailed}
+			}
+			set := func(key string, view *ByteView, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if attempt > 0 && err == nil {
+					g.Stats.ReplicaLoads.Add(1)
+				}
+				results[forwardFlight+key] = singleFlight.Result{Val: view, Err: err}
+			}
+			var wg sync.WaitGroup
+			pending = pending[:0:0]
+			for peer, peerKeys := range byPeer {
+				wg.Add(1)
+				go func(peer PeerGetter, peerKeys []string) {
+					defer wg.Done()
+					peerFailed := g.getMultiFromPeer(loadCtx, peer, peerKeys, set)
+					mu.Lock()
+					pending = append(pending, peerFailed...)
+					mu.Unlock()
+				}(peer, peerKeys)
+			}
+			wg.Wait()
+		}
+		return results
+	})
+	for flight, result := range results {
+		key := strings.TrimPrefix(flight, forwardFlight)
+		switch {
+		case errors.Is(result.Err, errOwnersFailed):
+			failed = append(failed, key)
+		case result.Err != nil:
+			set(key, &ByteView{}, result.Err)
+		default:
+			set(key, result.Val.(*ByteView), nil)
+		}
+	}
+	return failed
+}
+
+// getMultiFromPeer 从owner获取keys，返回需要尝试下一个owner(或者在本地加载)的key
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerGetter, keys []string, set func(key string, view *ByteView, err error)) (failed []string) {
+	batch, ok := peer.(BatchPeerGetter)
+	if !ok {
+		for _, key := range keys {
+			view, err := g.getFormPeer(ctx, peer, key)
+			switch {
+			case err == nil:
+				g.Stats.PeerLoads.Add(1)
+				set(key, view, nil)
+			case errors.Is(err, ErrNotFound):
+				g.Stats.PeerLoads.Add(1)
+				g.populateNegative(key)
+				set(key, &ByteView{}, err)
+			default:
+				g.Stats.PeerErrors.Add(1)
+				failed = append(failed, key)
+			}
+		}
+		return failed
+	}
+
+	res := &pb.GetMultiResponse{}
+	if err := batch.GetMulti(ctx, &pb.GetMultiRequest{Group: g.name, Keys: keys}, res); err != nil {
+		g.Stats.PeerErrors.Add(1)
+		g.logger.Warn("get multi from peer failed, try next owner", peerAttr(peer), "keys", len(keys), "err", err)
+		return keys
+	}
+	g.Stats.BatchLoads.Add(1)
+	got := make(map[string]bool, len(keys))
+	for _, r := range res.GetResults() {
+		key := r.GetKey()
+		got[key] = true
+		switch {
+		case r.GetNotFound():
+			g.Stats.PeerLoads.Add(1)
+			g.populateNegative(key)
+			set(key, &ByteView{}, ErrNotFound)
+		case r.GetError() != "":
+			g.Stats.PeerErrors.Add(1)
+			failed = append(failed, key)
+		default:
+			g.Stats.PeerLoads.Add(1)
+			set(key, g.peerView(key, r.GetValue(), r.GetExpire()), nil)
+		}
+	}
+	//todo owner没有返回的key也在本地加载
+	for _, key := range keys {
+		if !got[key] {
+			failed = append(failed, key)
+		}
+	}
+	return failed
+}
+
+// getMultiLocally 调用本地getter，getter没有实现BatchGetter时逐个加载，两种情况下每个key都经过singleFlight
+func (g *Group) getMultiLocally(ctx context.Context, keys []string, set func(key string, view *ByteView, err error)) {
+	batch, ok := g.getter.(BatchGetter)
+	if !ok {
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				view, err, _ := g.single.DoWithContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+					return g.getLocally(ctx, key)
+				})
+				if err != nil {
+					set(key, &ByteView{}, err)
+					return
+				}
+				set(key, view.(*ByteView), nil)
+			}(key)
+		}
+		wg.Wait()
+		return
+	}
+
+	//todo 过滤器在这里(加载数据的结点上)检查，一定不存在的key不交给getter
+	passed := make([]string, 0, len(keys))
+	checked := false
+	for _, key := range keys {
+		rejected, ok := g.bloomRejects(key)
+		if rejected {
+			set(key, &ByteView{}, ErrNotFound)
+			continue
+		}
+		checked = checked || ok
+		passed = append(passed, key)
+	}
+	if len(passed) == 0 {
+		return
+	}
+	//todo 与同时进行的Get共用singleFlight，已经在加载的key等待原来的结果，其余的key一次BatchGetter.GetMulti
+	results := g.single.DoMulti(ctx, passed, func(loadCtx context.Context, keys []string) map[string]singleFlight.Result {
+		g.Stats.LocalLoads.Add(int64(len(keys)))
+		g.Stats.BatchLoads.Add(1)
+		spanCtx, span := startSpan(loadCtx, "BatchGetter.GetMulti", trace.WithAttributes(attribute.Int("cache.keys", len(keys))))
+		values, err := batch.GetMulti(spanCtx, keys)
+		endSpan(span, err)
+		results := make(map[string]singleFlight.Result, len(keys))
+		for _, key := range keys {
+			if err != nil {
+				results[key] = singleFlight.Result{Err: err}
+				continue
+			}
+			value, ok := values[key]
+			if !ok {
+				g.populateNegative(key)
+				g.bloomFalsePositive(checked)
+				results[key] = singleFlight.Result{Err: ErrNotFound}
+				continue
+			}
+			view := &ByteView{byteView: cloneByte(value), expire: g.expireAt(0)}
+			g.populateCache(key, view)
+			results[key] = singleFlight.Result{Val: view}
+		}
+		return results
+	})
+	for key, result := range results {
+		if result.Err != nil {
+			set(key, &ByteView{}, result.Err)
+			continue
+		}
+		set(key, result.Val.(*ByteView), nil)
+	}
+}
+
+// multiResponse 把GetMulti的结果按请求中key的顺序转换成pb.GetMultiResponse，供HTTP和gRPC服务端使用
+func multiResponse(keys []string, results map[string]Result) *pb.GetMultiResponse {
+	res := &pb.GetMultiResponse{Results: make([]*pb.KeyResult, 0, len(keys))}
+	for _, key := range keys {
+		result := results[key]
+		r := &pb.KeyResult{Key: key}
+		switch {
+		case errors.Is(result.Err, ErrNotFound):
+			r.NotFound = true
+		case result.Err != nil:
+			r.Error = result.Err.Error()
+		default:
+			r.Value = result.View.ByteSlice()
+			r.Expire = result.View.expireUnixNano()
+		}
+		res.Results = append(res.Results, r)
+	}
+	return res
+}
@@ -0,0 +1,355 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetMulti(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	gee := NewGroup("multi-scores", 2<<10, BatchGetterHandler(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			if v, ok := db[key]; ok {
+				values[key] = []byte(v)
+			}
+		}
+		return values, nil
+	}))
+
+	results := gee.GetMulti(context.Background(), []string{"Tom", "Jack", "unknown", "Tom", ""})
+	if len(results) != 4 {
+		t.Fatalf("duplicate keys should be merged, got %d results", len(results))
+	}
+	for _, key := range []string{"Tom", "Jack"} {
+		if r := results[key]; r.Err != nil || r.View.String() != db[key] {
+			t.Fatalf("%s should be %s, got %v %v", key, db[key], r.View, r.Err)
+		}
+	}
+	if !errors.Is(results["unknown"].Err, ErrNotFound) || results[""].Err == nil {
+		t.Fatalf("unexpected errors %v %v", results["unknown"].Err, results[""].Err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("getter should be called once with 3 keys, got %v", batches)
+	}
+
+	//todo 第二次全部命中mainCache和negativeCache
+	results = gee.GetMulti(context.Background(), []string{"Tom", "unknown"})
+	if results["Tom"].View.String() != "630" || !errors.Is(results["unknown"].Err, ErrNotFound) || len(batches) != 1 {
+		t.Fatalf("second GetMulti should be served from the cache, batches %v", batches)
+	}
+	if gee.Stats.CacheHits.Load() != 1 || gee.Stats.NegativeHits.Load() != 1 {
+		t.Fatalf("cache hits %d, negative hits %d", gee.Stats.CacheHits.Load(), gee.Stats.NegativeHits.Load())
+	}
+}
+
+// batchPeer 实现了BatchPeerGetter，failed为true时整个请求失败
+type batchPeer struct {
+	fakePeer
+	failed bool
+	calls  [][]string
+}
+
+func (b *batchPeer) GetMulti(ctx context.Context, req *pb.GetMultiRequest, res *pb.GetMultiResponse) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, req.GetKeys())
+	if b.failed {
+		return errors.New("peer is down")
+	}
+	for _, key := range req.GetKeys() {
+		switch {
+		case strings.HasSuffix(key, "missing"):
+			res.Results = append(res.Results, &pb.KeyResult{Key: key, NotFound: true})
+		case strings.HasSuffix(key, "broken"):
+			res.Results = append(res.Results, &pb.KeyResult{Key: key, Error: "load failed"})
+		default:
+			res.Results = append(res.Results, &pb.KeyResult{Key: key, Value: []byte("peer-" + key)})
+		}
+	}
+	return nil
+}
+
+// prefixPicker a开头的key属于a，b开头的key属于b，其余的属于自己
+type prefixPicker struct {
+	a, b PeerGetter
+}
+
+func (p *prefixPicker) PickPeer(key string) (PeerGetter, bool) {
+	switch key[0] {
+	case 'a':
+		return p.a, true
+	case 'b':
+		return p.b, true
+	}
+	return nil, false
+}
+
+func (p *prefixPicker) GetAll() []PeerGetter {
+	return []PeerGetter{p.a, p.b}
+}
+
+func TestGetMultiPeers(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	gee := NewGroup("multi-peer-scores", 2<<10, BatchGetterHandler(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, keys)
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			values[key] = []byte("local-" + key)
+		}
+		return values, nil
+	}), WithHotCache(0))
+	a, b := &batchPeer{}, &batchPeer{failed: true}
+	gee.RegisterPeers(&prefixPicker{a: a, b: b})
+
+	keys := []string{"a1", "a2", "amissing", "abroken", "b1", "b2", "c1"}
+	results := gee.GetMulti(context.Background(), keys)
+	want := map[string]string{
+		"a1": "peer-a1", "a2": "peer-a2", "abroken": "local-abroken",
+		"b1": "local-b1", "b2": "local-b2", "c1": "local-c1",
+	}
+	for key, value := range want {
+		if r := results[key]; r.Err != nil || r.View.String() != value {
+			t.Fatalf("%s should be %s, got %v %v", key, value, r.View, r.Err)
+		}
+	}
+	if !errors.Is(results["amissing"].Err, ErrNotFound) {
+		t.Fatalf("amissing should be not found, got %v", results["amissing"].Err)
+	}
+	//todo 每个owner只有一次请求，失败的key和自己的key合并成一次本地加载
+	if len(a.calls) != 1 || len(a.calls[0]) != 4 || len(b.calls) != 1 || len(b.calls[0]) != 2 {
+		t.Fatalf("each peer should get one batch, a %v, b %v", a.calls, b.calls)
+	}
+	if len(batches) != 1 || len(batches[0]) != 4 {
+		t.Fatalf("local getter should be called once with 4 keys, got %v", batches)
+	}
+	if gee.Stats.PeerErrors.Load() != 2 || gee.Stats.BatchLoads.Load() != 2 {
+		t.Fatalf("peer errors %d, batch loads %d", gee.Stats.PeerErrors.Load(), gee.Stats.BatchLoads.Load())
+	}
+}
+
+func TestGetMultiFromPeer(t *testing.T) {
+	var batches [][]string
+	gee := NewGroup("multi-from-peer-scores", 2<<10, BatchGetterHandler(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		batches = append(batches, keys)
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			values[key] = []byte("local-" + key)
+		}
+		return values, nil
+	}))
+	a, b := &batchPeer{}, &batchPeer{}
+	gee.RegisterPeers(&prefixPicker{a: a, b: b})
+
+	//todo 来自peer的请求不再转发，全部在本地加载
+	results := gee.GetMulti(withFromPeer(context.Background()), []string{"a1", "b1", "c1"})
+	for _, key := range []string{"a1", "b1", "c1"} {
+		if r := results[key]; r.Err != nil || r.View.String() != "local-"+key {
+			t.Fatalf("%s should be loaded locally, got %v %v", key, r.View, r.Err)
+		}
+	}
+	if len(a.calls) != 0 || len(b.calls) != 0 || len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("peer request should not be forwarded, a %v, b %v, batches %v", a.calls, b.calls, batches)
+	}
+}
+
+func TestGetMultiDedupWithGet(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	gee := NewGroup("multi-dedup-scores", 2<<10, BatchGetterHandler(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		first := len(batches) == 1
+		mu.Unlock()
+		if first {
+			close(started)
+			<-release
+		}
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			values[key] = []byte("db-" + key)
+		}
+		return values, nil
+	}))
+
+	got := make(chan string)
+	go func() {
+		view, _ := gee.Get("Tom")
+		got <- view.String()
+	}()
+	<-started
+
+	//todo Tom正在被Get加载，GetMulti等待它的结果，只加载Jack
+	done := make(chan map[string]Result)
+	go func() {
+		done <- gee.GetMulti(context.Background(), []string{"Tom", "Jack"})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	results := <-done
+	if v := <-got; v != "db-Tom" {
+		t.Fatalf("Get should return db-Tom, got %s", v)
+	}
+	if results["Tom"].View.String() != "db-Tom" || results["Jack"].View.String() != "db-Jack" {
+		t.Fatalf("unexpected results %v", results)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[1]) != 1 || batches[1][0] != "Jack" {
+		t.Fatalf("Tom should be loaded once, got %v", batches)
+	}
+}
+
+func TestGetMultiReplicaFailover(t *testing.T) {
+	var localLoads atomic.Int64
+	gee := NewGroup("multi-replica-scores", 2<<10, BatchGetterHandler(func(ctx context.Context, keys []string) (map[string][]byte, error) {
+		localLoads.Add(int64(len(keys)))
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			values[key] = []byte("local-" + key)
+		}
+		return values, nil
+	}), WithReplicas(2), WithHotCache(0))
+	dead, live := &batchPeer{failed: true}, &batchPeer{}
+	gee.RegisterPeers(&replicaPicker{fakePicker: fakePicker{owner: &dead.fakePeer}, replicas: []PeerGetter{dead, live}})
+
+	//todo owner失败后与Get一样尝试下一个owner，不直接交给本地getter
+	results := gee.GetMulti(context.Background(), []string{"Tom", "Jack"})
+	for _, key := range []string{"Tom", "Jack"} {
+		if r := results[key]; r.Err != nil || r.View.String() != "peer-"+key {
+			t.Fatalf("%s should come from the replica, got %v %v", key, r.View, r.Err)
+		}
+	}
+	if len(dead.calls) != 1 || len(live.calls) != 1 || len(live.calls[0]) != 2 {
+		t.Fatalf("each owner should get one batch, dead %v, live %v", dead.calls, live.calls)
+	}
+	if localLoads.Load() != 0 || gee.Stats.ReplicaLoads.Load() != 2 {
+		t.Fatalf("local loads %d, replica loads %d", localLoads.Load(), gee.Stats.ReplicaLoads.Load())
+	}
+
+	//todo 所有owner都失败时才在本地加载
+	live.failed = true
+	results = gee.GetMulti(context.Background(), []string{"Sam"})
+	if r := results["Sam"]; r.Err != nil || r.View.String() != "local-Sam" || localLoads.Load() != 1 {
+		t.Fatalf("Sam should be loaded locally, got %v %v", r.View, r.Err)
+	}
+}
+
+// blockingPeer Get在release关闭之前不会返回
+type blockingPeer struct {
+	batchPeer
+	gets    atomic.Int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingPeer) Get(ctx context.Context, req *pb.Request, res *pb.Response) error {
+	if b.gets.Add(1) == 1 {
+		close(b.started)
+	}
+	<-b.release
+	res.Value = []byte("peer-" + req.GetKey())
+	return nil
+}
+
+func TestGetMultiDedupRemote(t *testing.T) {
+	gee := NewGroup("multi-dedup-remote-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte("local-" + key), nil
+	}), WithHotCache(0))
+	peer := &blockingPeer{started: make(chan struct{}), release: make(chan struct{})}
+	gee.RegisterPeers(&prefixPicker{a: peer})
+
+	got := make(chan string)
+	go func() {
+		view, _ := gee.Get("a1")
+		got <- view.String()
+	}()
+	<-peer.started
+
+	//todo a1正在被Get转发，GetMulti等待它的结果，只向owner请求a2
+	done := make(chan map[string]Result)
+	go func() {
+		done <- gee.GetMulti(context.Background(), []string{"a1", "a2"})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(peer.release)
+	results := <-done
+	if v := <-got; v != "peer-a1" {
+		t.Fatalf("Get should return peer-a1, got %s", v)
+	}
+	if results["a1"].View.String() != "peer-a1" || results["a2"].View.String() != "peer-a2" {
+		t.Fatalf("unexpected results %v", results)
+	}
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.gets.Load() != 1 || len(peer.calls) != 1 || len(peer.calls[0]) != 1 || peer.calls[0][0] != "a2" {
+		t.Fatalf("a1 should be fetched once, gets %d, batches %v", peer.gets.Load(), peer.calls)
+	}
+}
+
+func TestGetMultiTransport(t *testing.T) {
+	NewGroup("multi-transport-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, ErrNotFound
+	}))
+
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	httpGetter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewGRPCPool(lis.Addr().String())
+	defer resetPool()
+	go func() {
+		_ = pool.Serve(lis)
+	}()
+	defer pool.Stop()
+	grpcGetter, err := NewGrpcGetter(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer grpcGetter.Close()
+
+	for name, getter := range map[string]BatchPeerGetter{"http": httpGetter, "grpc": grpcGetter} {
+		res := &pb.GetMultiResponse{}
+		req := &pb.GetMultiRequest{Group: "multi-transport-scores", Keys: []string{"Tom", "unknown", "Sam"}}
+		if err := getter.GetMulti(context.Background(), req, res); err != nil {
+			t.Fatalf("%s GetMulti failed: %v", name, err)
+		}
+		r := res.GetResults()
+		if len(r) != 3 || string(r[0].GetValue()) != "630" || !r[1].GetNotFound() || string(r[2].GetValue()) != "567" {
+			t.Fatalf("%s GetMulti returned %v", name, r)
+		}
+		if err := getter.GetMulti(context.Background(), &pb.GetMultiRequest{Group: "no-such-group"}, res); err == nil {
+			t.Fatalf("%s no-such-group should return error", name)
+		}
+	}
+}
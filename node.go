@@ -0,0 +1,166 @@
+package simpleCache
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"github.com/thewisecirno/simple_distributed_cache/etcd"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Node 一个缓存结点，拥有自己的groups、peer picker以及服务发现使用的etcd client，
+// 同一个进程中可以创建多个互不影响的Node，例如在一个测试中启动多个结点组成集群
+type Node struct {
+	mu     sync.RWMutex
+	groups map[string]*Group
+	//之后创建的Group都使用peers从其他结点获取数据
+	peers PeerPicker
+	//NewHTTPPoolWithEtcd创建的etcd client，Close时关闭
+	etcdClient *clientv3.Client
+
+	metricsOnce sync.Once
+	metrics     http.Handler
+	//n中的HTTPPool和GRPCPool创建的getter请求peer的耗时
+	peerRequests *prometheus.HistogramVec
+}
+
+func NewNode() *Node {
+	return &Node{
+		groups:       make(map[string]*Group),
+		peerRequests: newPeerRequestDuration(),
+	}
+}
+
+// defaultNode 包级别的NewGroup、GetGroup、NewHTTPPool等函数都作用在defaultNode上
+var defaultNode = NewNode()
+
+// DefaultNode 返回包级别函数使用的Node
+func DefaultNode() *Node {
+	return defaultNode
+}
+
+// NewGroup 在n中创建Group，同名的Group会被替换，n已经有peers时Group会自动RegisterPeers
+func (n *Node) NewGroup(groupName string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
+	group := newGroup(groupName, cacheBytes, getter, opts...)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	//todo 被替换的Group不再被访问，停止它的后台goroutine
+	if old, ok := n.groups[groupName]; ok {
+		old.Close()
+	}
+	n.groups[groupName] = group
+	//todo 还没有创建HTTPPool或GRPCPool时peers为nil，Group只从本地加载，之后可以自己RegisterPeers
+	if n.peers != nil {
+		group.RegisterPeers(n.peers)
+	}
+	return group
+}
+
+func (n *Node) GetGroup(groupName string) *Group {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.groups[groupName]
+}
+
+// Groups 返回n中所有的Group，按名字排序
+func (n *Node) Groups() []*Group {
+	n.mu.RLock()
+	snapshot := make([]*Group, 0, len(n.groups))
+	for _, group := range n.groups {
+		snapshot = append(snapshot, group)
+	}
+	n.mu.RUnlock()
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].name < snapshot[j].name
+	})
+	return snapshot
+}
+
+// RegisterPeers 设置之后创建的Group使用的peers，已经创建的Group不受影响，peers为nil时之后的Group只从本地加载
+func (n *Node) RegisterPeers(peers PeerPicker) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.peers = peers
+}
+
+// NewHTTPPool 创建属于n的HTTPPool，ServeHTTP只访问n中的Group，之后在n中创建的Group都通过它访问其他结点
+func (n *Node) NewHTTPPool(self string, base string, d discovery.Discovery, opts ...HTTPPoolOption) *HTTPPool {
+	pool := newHTTPPool(n, self, base, d, opts...)
+	n.RegisterPeers(pool)
+	return pool
+}
+
+// NewHTTPPoolWithEtcd 根据configEtcd创建etcd client并用它做服务发现，client属于n，Close时关闭
+func (n *Node) NewHTTPPoolWithEtcd(self string, base string, configEtcd *etcd.ConfigEtcd, opts ...HTTPPoolOption) (*HTTPPool, error) {
+	client, err := configEtcd.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	if n.etcdClient != nil {
+		n.mu.Unlock()
+		_ = client.Close()
+		return nil, errors.New("node already has an etcd client")
+	}
+	n.etcdClient = client
+	n.mu.Unlock()
+	return n.NewHTTPPool(self, base, etcd.NewDiscovery(client, configEtcd.WatcherTime, configEtcd.TTL, etcd.WithLogger(configEtcd.Logger)), opts...), nil
+}
+
+// NewGRPCPool 创建属于n的GRPCPool，gRPC服务只访问n中的Group，之后在n中创建的Group都通过它访问其他结点
+func (n *Node) NewGRPCPool(self string, opts ...GRPCPoolOption) *GRPCPool {
+	pool := newGRPCPool(n, self, opts...)
+	n.RegisterPeers(pool)
+	return pool
+}
+
+// Collector 返回n中所有Group的Prometheus指标，包级别的Registry只包含defaultNode，其他Node需要注册到自己的Registry中
+func (n *Node) Collector() prometheus.Collector {
+	return groupCollector{node: n}
+}
+
+// MetricsHandler 返回n的Prometheus指标，defaultNode使用包级别的Registry，其他Node使用自己的Registry，
+// 其中包括n中所有Group的指标、请求peer的耗时以及Go运行时的指标
+func (n *Node) MetricsHandler() http.Handler {
+	if n == defaultNode {
+		return MetricsHandler()
+	}
+	n.metricsOnce.Do(func() {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			n.Collector(),
+			n.peerRequests,
+			prometheus.NewGoCollector(),
+			prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		)
+		n.metrics = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	})
+	return n.metrics
+}
+
+// Close 停止n中所有Group的后台goroutine并关闭n拥有的etcd client
+func (n *Node) Close() error {
+	for _, group := range n.Groups() {
+		group.Close()
+	}
+	n.mu.Lock()
+	client := n.etcdClient
+	n.etcdClient = nil
+	n.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// orDefault HTTPPool和GRPCPool可能不是通过构造函数创建的(例如测试中的&HTTPPool{})，node为nil时使用defaultNode
+func orDefault(n *Node) *Node {
+	if n == nil {
+		return defaultNode
+	}
+	return n
+}
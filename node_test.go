@@ -0,0 +1,75 @@
+package simpleCache
+
+import (
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// resetPool 测试结束后清除defaultNode的peers，之后创建的Group不会访问已经关闭的结点
+func resetPool() {
+	Pool = nil
+	defaultNode.RegisterPeers(nil)
+}
+
+func TestNodeCluster(t *testing.T) {
+	servers := []*httptest.Server{httptest.NewUnstartedServer(nil), httptest.NewUnstartedServer(nil)}
+	addrs := []string{servers[0].Listener.Addr().String(), servers[1].Listener.Addr().String()}
+	nodes := []*Node{NewNode(), NewNode()}
+	pools := make([]*HTTPPool, len(nodes))
+	loads := make([]atomic.Int64, len(nodes))
+	for i, node := range nodes {
+		i := i
+		pools[i] = node.NewHTTPPool(addrs[i], "", discovery.NewStatic(addrs...))
+		defer pools[i].stopWatch()
+		servers[i].Config.Handler = pools[i]
+		servers[i].Start()
+		defer servers[i].Close()
+		//todo 两个结点有同名的Group，getter互不影响
+		node.NewGroup("scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+			loads[i].Add(1)
+			return []byte(key), nil
+		}))
+	}
+	waitPicked(t, pools[0], addrs[0], addrs[1]+defaultBasePath)
+	waitPicked(t, pools[1], addrs[1], addrs[0]+defaultBasePath)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		key := "key" + strings.Repeat("x", i)
+		view, err := nodes[0].GetGroup("scores").Get(key)
+		if err != nil || view.String() != key {
+			t.Fatalf("get %s failed: %v %v", key, view, err)
+		}
+	}
+	//todo 每个key只由owner加载一次，部分key由另一个结点加载
+	if total := loads[0].Load() + loads[1].Load(); total != n {
+		t.Fatalf("each key should be loaded once, got %d", total)
+	}
+	if loads[1].Load() == 0 {
+		t.Fatal("keys owned by the second node should be loaded there")
+	}
+	if nodes[1].GetGroup("scores").Stats.Gets.Load() != loads[1].Load() {
+		t.Fatal("the second node should only serve the keys it owns")
+	}
+	if GetGroup("scores") == nodes[0].GetGroup("scores") || Pool == pools[0] {
+		t.Fatal("nodes should not touch the default node")
+	}
+}
+
+func TestNodeGroups(t *testing.T) {
+	node := NewNode()
+	b := node.NewGroup("b", 2<<10, GetterHandler(func(key string) ([]byte, error) { return nil, ErrNotFound }))
+	a := node.NewGroup("a", 2<<10, GetterHandler(func(key string) ([]byte, error) { return nil, ErrNotFound }))
+	if groups := node.Groups(); len(groups) != 2 || groups[0] != a || groups[1] != b {
+		t.Fatalf("unexpected groups %v", groups)
+	}
+	if node.GetGroup("c") != nil || a.peers != nil {
+		t.Fatal("a node without peers should only load locally")
+	}
+	if err := node.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
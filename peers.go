@@ -1,16 +1,66 @@
 package simpleCache
 
 import (
+	"context"
 	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"time"
 )
 
+// defaultPeerTimeout HttpGetter和GrpcGetter请求peer时ctx没有deadline的默认超时时间
+const defaultPeerTimeout = time.Second * 5
+
+// withPeerTimeout ctx没有deadline时使用defaultPeerTimeout，避免peer没有响应时一直等待
+func withPeerTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, defaultPeerTimeout)
+}
+
+type fromPeerKey struct{}
+
+// withFromPeer 标记请求是其他peer转发过来的。转发方已经选过owner(bounded load、副本failover等)，
+// 收到的结点直接在本地加载，不再调用PickPeer，避免结点之间看法不一致时来回转发
+func withFromPeer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fromPeerKey{}, true)
+}
+
+func isFromPeer(ctx context.Context) bool {
+	fromPeer, _ := ctx.Value(fromPeerKey{}).(bool)
+	return fromPeer
+}
+
 // PeerPicker 抽象出一个key对应一个peerGetter
 type PeerPicker interface {
 	PickPeer(key string) (PeerGetter, bool)
 }
 
-// PeerGetter 通过group_name和key获取到实际对应的值
+// PeerLister 可选接口，GetAll返回除自己以外的所有peer，Set和Remove用它通知其他peer删除hotCache中的副本
+type PeerLister interface {
+	GetAll() []PeerGetter
+}
+
+// ReplicaPicker 可选接口，PickReplicas按优先级返回key的前n个owner中排在self之前的peer
+// self是首选owner时返回空，self不在前n个owner中时返回n个peer
+type ReplicaPicker interface {
+	PickReplicas(key string, n int) []PeerGetter
+}
+
+// OwnerPicker 可选接口，PickOwner返回哈希环上负责key的peer(不受bounded load影响)，self是owner时返回false
+// PickPeer可能为了分摊负载返回其他peer，只适合读取；Set和Remove需要写到真正的owner上
+type OwnerPicker interface {
+	PickOwner(key string) (PeerGetter, bool)
+}
+
+// PeerGetter 通过group_name和key获取到实际对应的值，Set和Remove用于更新或删除owner结点上的缓存
 type PeerGetter interface {
-	Get(request *pb.Request, response *pb.Response) error
+	Get(ctx context.Context, request *pb.Request, response *pb.Response) error
+	Set(ctx context.Context, request *pb.SetRequest) error
+	Remove(ctx context.Context, request *pb.Request) error
 	//Get(group, key string) ([]byte, error)
 }
+
+// BatchPeerGetter 可选接口，一次请求获取多个key，Group.GetMulti会把同一个owner的key合并成一次GetMulti
+type BatchPeerGetter interface {
+	GetMulti(ctx context.Context, request *pb.GetMultiRequest, response *pb.GetMultiResponse) error
+}
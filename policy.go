@@ -0,0 +1,74 @@
+package simpleCache
+
+import (
+	"github.com/thewisecirno/simple_distributed_cache/arc"
+	"github.com/thewisecirno/simple_distributed_cache/lfu"
+	"github.com/thewisecirno/simple_distributed_cache/lru"
+	"github.com/thewisecirno/simple_distributed_cache/tinylfu"
+	"time"
+)
+
+// Policy 缓存淘汰策略，实现都不是并发安全的，由cache负责加锁
+type Policy interface {
+	AddWithExpire(key string, val lru.Value, expireAt time.Time)
+	Get(key string) (lru.Value, bool)
+	// Hottest 按淘汰策略认为的热度从高到低，最多为n条没有过期的记录调用fn
+	Hottest(n int, fn func(key string, val lru.Value, expire time.Time))
+	Remove(key string)
+	RemoveExpired() int
+	Len() int
+	// Bytes 当前占用的字节数
+	Bytes() int64
+}
+
+type PolicyType int
+
+const (
+	LRU PolicyType = iota
+	LFU
+	ARC
+	// TinyLFU W-TinyLFU，适合带有大量扫描流量的场景
+	TinyLFU
+)
+
+func (t PolicyType) String() string {
+	switch t {
+	case LRU:
+		return "lru"
+	case LFU:
+		return "lfu"
+	case ARC:
+		return "arc"
+	case TinyLFU:
+		return "tinylfu"
+	}
+	return "unknown"
+}
+
+// newPolicy onEvicted在记录因为容量不足或者过期被删除时调用
+func newPolicy(t PolicyType, maxBytes int64, onEvicted func(key string, value lru.Value)) Policy {
+	switch t {
+	case LFU:
+		return lfu.NewCache(maxBytes, onEvicted)
+	case ARC:
+		return arc.NewCache(maxBytes, onEvicted)
+	case TinyLFU:
+		return tinylfu.NewCache(maxBytes, onEvicted)
+	default:
+		return lru.NewCache(maxBytes, onEvicted)
+	}
+}
+
+// WithPolicy 设置mainCache使用的淘汰策略，默认为LRU
+func WithPolicy(t PolicyType) GroupOption {
+	return func(g *Group) {
+		g.policy = t
+	}
+}
+
+var (
+	_ Policy = (*lru.Cache)(nil)
+	_ Policy = (*lfu.Cache)(nil)
+	_ Policy = (*arc.Cache)(nil)
+	_ Policy = (*tinylfu.Cache)(nil)
+)
@@ -0,0 +1,126 @@
+package simpleCache
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	traceKeys      = 20000
+	traceRequests  = 200000
+	traceScanEvery = 20000
+	traceScanSize  = 5000
+	traceValueSize = 64
+)
+
+// loadTrace 如果设置了CACHE_TRACE环境变量，就从该文件读取访问记录(每行一个key)，
+// 否则生成一个zipf分布并夹杂着周期性扫描的访问记录
+func loadTrace(tb testing.TB) []string {
+	path := os.Getenv("CACHE_TRACE")
+	if path == "" {
+		return zipfScanTrace()
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	trace := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			trace = append(trace, key)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return trace
+}
+
+func zipfScanTrace() []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, traceKeys-1)
+	trace := make([]string, 0, traceRequests)
+	scan := 0
+	for i := 0; i < traceRequests; i++ {
+		if i > 0 && i%traceScanEvery == 0 {
+			//todo 模拟批处理任务，一次性访问大量只出现一次的key
+			for j := 0; j < traceScanSize; j++ {
+				trace = append(trace, fmt.Sprintf("scan-%d", scan))
+				scan++
+			}
+		}
+		trace = append(trace, fmt.Sprintf("key-%d", zipf.Uint64()))
+	}
+	return trace
+}
+
+func hitRatio(policy PolicyType, trace []string, cacheBytes int64) float64 {
+	c := &cache{policy: policy, cacheBytes: cacheBytes}
+	value := &ByteView{byteView: make([]byte, traceValueSize)}
+	hits := 0
+	for _, key := range trace {
+		if _, ok := c.get(key); ok {
+			hits++
+			continue
+		}
+		c.add(key, value)
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+var policies = []PolicyType{LRU, LFU, ARC, TinyLFU}
+
+func TestPolicy(t *testing.T) {
+	for _, policy := range policies {
+		gee := NewGroup("policy-"+policy.String(), 2<<10, GetterHandler(
+			func(key string) ([]byte, error) {
+				if v, ok := db[key]; ok {
+					return []byte(v), nil
+				}
+				return nil, fmt.Errorf("%s not exist", key)
+			}), WithPolicy(policy))
+		for k, v := range db {
+			if view, err := gee.Get(k); err != nil || view.String() != v {
+				t.Fatalf("%s: failed to get value of %s", policy, k)
+			}
+			if _, ok := gee.mainCache.get(k); !ok {
+				t.Fatalf("%s: cache %s miss", policy, k)
+			}
+		}
+	}
+}
+
+func TestPolicyScanResistance(t *testing.T) {
+	trace := zipfScanTrace()
+	cacheBytes := int64(1000 * (traceValueSize + 10))
+	ratios := make(map[PolicyType]float64, len(policies))
+	for _, policy := range policies {
+		ratios[policy] = hitRatio(policy, trace, cacheBytes)
+		t.Logf("%-8s hit ratio %.2f%%", policy, ratios[policy]*100)
+	}
+	for _, policy := range []PolicyType{ARC, TinyLFU} {
+		if ratios[policy] < ratios[LRU] {
+			t.Errorf("%s hit ratio %.4f should not be lower than lru %.4f", policy, ratios[policy], ratios[LRU])
+		}
+	}
+}
+
+func BenchmarkPolicyHitRatio(b *testing.B) {
+	trace := loadTrace(b)
+	cacheBytes := int64(1000 * (traceValueSize + 10))
+	for _, policy := range policies {
+		b.Run(policy.String(), func(b *testing.B) {
+			var ratio float64
+			for i := 0; i < b.N; i++ {
+				ratio = hitRatio(policy, trace, cacheBytes)
+			}
+			b.ReportMetric(ratio*100, "hit%")
+		})
+	}
+}
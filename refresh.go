@@ -0,0 +1,119 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultRefreshBackoff 后台刷新失败后，同一条记录至少间隔这么久才会再次刷新
+const defaultRefreshBackoff = time.Second
+
+// WithRefreshAhead 记录剩余的有效期不足TTL的fraction时，Get照常返回它，同时在后台重新加载一次，
+// 热点key在过期之前就会被刷新，调用者不会因为过期而阻塞在getter上。fraction应该在(0,1)之间
+func WithRefreshAhead(fraction float64) GroupOption {
+	if fraction <= 0 || fraction >= 1 {
+		panic("refresh ahead fraction should be in (0, 1)")
+	}
+	return func(g *Group) {
+		g.refreshAhead = fraction
+	}
+}
+
+// WithStaleWhileRevalidate 记录过期后的window时间内，Get直接返回旧的值，同时在后台用singleFlight重新加载一次
+func WithStaleWhileRevalidate(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleWhileRevalidate = window
+	}
+}
+
+// WithStaleOnError 记录过期后的window时间内，如果重新加载失败(不包括ErrNotFound)，Get返回旧的值而不是错误
+func WithStaleOnError(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleOnError = window
+	}
+}
+
+// prepare 在写入mainCache之前计算记录的刷新时间和删除时间
+func (g *Group) prepare(val *ByteView) {
+	if val.expire.IsZero() {
+		return
+	}
+	if g.refreshAhead > 0 {
+		remaining := time.Until(val.expire)
+		val.refreshAt.Store(val.expire.Add(-time.Duration(float64(remaining) * g.refreshAhead)).UnixNano())
+	}
+	retain := g.staleWhileRevalidate
+	if g.staleOnError > retain {
+		retain = g.staleOnError
+	}
+	if retain > 0 {
+		val.evictAt = val.expire.Add(retain)
+	}
+}
+
+// serveCached 判断mainCache中的记录能否直接返回，需要时在后台重新加载
+func (g *Group) serveCached(key string, view *ByteView) bool {
+	now := time.Now()
+	if !view.expired(now) {
+		g.Stats.CacheHits.Add(1)
+		if view.refreshAt.Load() != 0 {
+			g.tryRefresh(key, view, now)
+		}
+		return true
+	}
+	if g.staleWhileRevalidate > 0 && now.Before(view.expire.Add(g.staleWhileRevalidate)) {
+		g.Stats.StaleHits.Add(1)
+		g.tryRefresh(key, view, now)
+		return true
+	}
+	return false
+}
+
+// tryRefresh 到了刷新时间时先把refreshAt推后defaultRefreshBackoff再刷新，同一时刻只有一个调用者能推后成功
+// 刷新成功时记录会被替换，失败时旧记录留在缓存中，backoff之内的命中不会再次访问getter
+func (g *Group) tryRefresh(key string, view *ByteView, now time.Time) {
+	at := view.refreshAt.Load()
+	if now.UnixNano() < at {
+		return
+	}
+	if view.refreshAt.CompareAndSwap(at, now.Add(defaultRefreshBackoff).UnixNano()) {
+		g.refresh(key)
+	}
+}
+
+// serveStale 加载失败时判断能否返回过期的记录
+func (g *Group) serveStale(key string, stale *ByteView, err error) bool {
+	if stale == nil || g.staleOnError <= 0 || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if time.Now().After(stale.expire.Add(g.staleOnError)) {
+		return false
+	}
+	g.Stats.StaleErrors.Add(1)
+	g.logger.Warn("load failed, serve stale value", keyHash(key), "expired", stale.expire, "err", err)
+	return true
+}
+
+// refresh 在后台重新加载key，与正在进行的load和refresh共用同一次singleFlight调用
+// 需要刷新的记录来自mainCache，从peer获取到的值也写回mainCache，否则非owner结点上的记录(例如哈希环变化之前加载的)不会被续期
+func (g *Group) refresh(key string) {
+	flight, peers := g.flight(context.Background(), key)
+	ch := g.single.DoChan(flight, func() (interface{}, error) {
+		g.Stats.Refreshes.Add(1)
+		ctx, cancelFunc := g.refreshContext()
+		defer cancelFunc()
+		view, err := g.fetch(ctx, key, peers)
+		if err == nil && len(peers) > 0 {
+			g.populateCache(key, view.(*ByteView))
+		}
+		return view, err
+	})
+	//todo 没有调用者等待刷新的结果，失败时只记录下来，旧记录留在缓存中直到过期
+	go func() {
+		if res := <-ch; res.Err != nil && !errors.Is(res.Err, ErrNotFound) {
+			g.Stats.RefreshErrors.Add(1)
+			g.logger.Warn("refresh failed", keyHash(key), "err", res.Err)
+		}
+	}()
+}
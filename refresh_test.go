@@ -0,0 +1,176 @@
+package simpleCache
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitValue 等待后台刷新把key的值更新为want
+func waitValue(t *testing.T, g *Group, key, want string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if view, ok := g.mainCache.get(key); ok && view.String() == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not refreshed to %s", key, want)
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var version atomic.Int64
+	gee := NewGroup("refresh-ahead-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(strconv.FormatInt(version.Add(1), 10)), nil
+	}), WithTTL(200*time.Millisecond), WithRefreshAhead(0.5))
+
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+		t.Fatalf("Tom should be loaded, got %v %v", view, err)
+	}
+	if view, _ := gee.Get("Tom"); view.String() != "1" || gee.Stats.Refreshes.Load() != 0 {
+		t.Fatal("fresh entry should not be refreshed")
+	}
+
+	//todo 剩余有效期不足一半，返回旧值并在后台刷新
+	time.Sleep(120 * time.Millisecond)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+		t.Fatalf("Tom should still be served from the cache, got %v %v", view, err)
+	}
+	waitValue(t, gee, "Tom", "2")
+	if gee.Stats.Refreshes.Load() != 1 || gee.Stats.LocalLoads.Load() != 2 {
+		t.Fatalf("Tom should be refreshed once, refreshes %d", gee.Stats.Refreshes.Load())
+	}
+	if view, _ := gee.Get("Tom"); view.String() != "2" {
+		t.Fatalf("Tom should be the refreshed value, got %s", view.String())
+	}
+}
+
+func TestRefreshAheadNonOwner(t *testing.T) {
+	gee := NewGroup("refresh-non-owner-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte("1"), nil
+	}), WithTTL(200*time.Millisecond), WithRefreshAhead(0.5))
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+
+	//todo 哈希环变化后Tom属于另一个结点，刷新从owner获取并续期本地mainCache中的记录
+	picker := &fakePicker{owner: &fakePeer{}}
+	gee.RegisterPeers(picker)
+	time.Sleep(120 * time.Millisecond)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+		t.Fatalf("Tom should still be served from the cache, got %v %v", view, err)
+	}
+	waitValue(t, gee, "Tom", "peer-Tom")
+	if gee.Stats.Refreshes.Load() != 1 || gee.Stats.PeerLoads.Load() != 1 || gee.Stats.LocalLoads.Load() != 1 {
+		t.Fatalf("Tom should be refreshed from the owner once, refreshes %d, peer loads %d",
+			gee.Stats.Refreshes.Load(), gee.Stats.PeerLoads.Load())
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	var loads atomic.Int64
+	gee := NewGroup("refresh-backoff-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		if loads.Add(1) > 1 {
+			return nil, errors.New("db is down")
+		}
+		return []byte("1"), nil
+	}), WithTTL(time.Second), WithRefreshAhead(0.9))
+
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	//todo 刷新失败之后，backoff之内的命中不会再次刷新
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+			t.Fatalf("Tom should still be served from the cache, got %v %v", view, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if loads.Load() != 2 || gee.Stats.Refreshes.Load() != 1 || gee.Stats.RefreshErrors.Load() != 1 {
+		t.Fatalf("failed refresh should back off, loads %d, refreshes %d, errors %d",
+			loads.Load(), gee.Stats.Refreshes.Load(), gee.Stats.RefreshErrors.Load())
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var version atomic.Int64
+	release := make(chan struct{})
+	gee := NewGroup("stale-while-revalidate-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		if v := version.Add(1); v > 1 {
+			<-release
+			return []byte(strconv.FormatInt(v, 10)), nil
+		}
+		return []byte("1"), nil
+	}), WithTTL(50*time.Millisecond), WithStaleWhileRevalidate(time.Second))
+
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	//todo getter阻塞时所有调用者都立即拿到旧值，只有一次刷新
+	for i := 0; i < 5; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+			t.Fatalf("stale Tom should be served, got %v %v", view, err)
+		}
+	}
+	if gee.Stats.StaleHits.Load() != 5 {
+		t.Fatalf("stale hits should be 5, got %d", gee.Stats.StaleHits.Load())
+	}
+	close(release)
+	waitValue(t, gee, "Tom", "2")
+	if version.Load() != 2 || gee.Stats.Refreshes.Load() != 1 {
+		t.Fatalf("Tom should be reloaded once, loads %d, refreshes %d", version.Load(), gee.Stats.Refreshes.Load())
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	//0正常，1数据库不可用，2key不存在
+	var mode atomic.Int64
+	getter := GetterHandler(func(key string) ([]byte, error) {
+		switch mode.Load() {
+		case 1:
+			return nil, errors.New("db is down")
+		case 2:
+			return nil, ErrNotFound
+		}
+		return []byte(db[key]), nil
+	})
+	gee := NewGroup("stale-on-error-scores", 2<<10, getter, WithTTL(50*time.Millisecond), WithStaleOnError(time.Second))
+
+	if _, err := gee.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	mode.Store(1)
+	time.Sleep(60 * time.Millisecond)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("stale Tom should be served when the getter fails, got %v %v", view, err)
+	}
+	if gee.Stats.StaleErrors.Load() != 1 || gee.Stats.LocalLoads.Load() != 2 {
+		t.Fatalf("getter should be called again, stale errors %d", gee.Stats.StaleErrors.Load())
+	}
+
+	//todo key已经被删除时不能返回旧值
+	mode.Store(2)
+	if _, err := gee.Get("Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Tom should be not found, got %v", err)
+	}
+	if _, ok := gee.mainCache.get("Tom"); ok {
+		t.Fatal("stale Tom should be removed after ErrNotFound")
+	}
+
+	//todo 没有开启时返回错误
+	mode.Store(0)
+	other := NewGroup("no-stale-scores", 2<<10, getter, WithTTL(50*time.Millisecond))
+	if _, err := other.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	mode.Store(1)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := other.Get("Tom"); err == nil {
+		t.Fatal("expired Tom should not be served without WithStaleOnError")
+	}
+}
@@ -1,11 +1,147 @@
 package singleFlight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrGoexit fn调用了runtime.Goexit，DoChan的调用者会收到这个错误
+var ErrGoexit = errors.New("runtime.Goexit was called")
+
+// ErrNoResult DoMulti的fn没有返回某个key的结果
+var ErrNoResult = errors.New("singleflight: no result for key")
+
+// PanicError fn发生了panic，Do和DoContext的调用者会以它重新panic，DoChan的调用者会收到它作为错误
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.Value, p.Stack)
+}
+
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// Result DoChan的结果，Shared表示结果是否被多个调用者共享
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
+}
 
 type call struct {
-	wg  sync.WaitGroup
-	val any
-	err error
+	//fn执行结束后关闭
+	done chan struct{}
+	val  any
+	err  error
+
+	//除了第一个调用者之外的调用者数量，只在持有Group.mu时修改
+	dups  int
+	chans []chan<- Result
+	//被Forget之后，结束时不能再从calls中删除，因为key可能已经对应了新的call
+	forgotten bool
+
+	panicked bool
+	goexit   bool
+
+	//DoWithContext和DoMulti发起的调用中fn使用的ctx，其他调用为nil
+	ctx *callContext
+}
+
+// result 在done关闭之后调用，把fn的panic和Goexit传递给调用者
+func (c *call) result() (interface{}, error) {
+	if c.panicked {
+		panic(c.err)
+	}
+	if c.goexit {
+		runtime.Goexit()
+	}
+	return c.val, c.err
+}
+
+// callContext DoWithContext和DoMulti传给fn的ctx，保留发起调用的ctx中的值，deadline是还在等待的调用者中最晚的deadline，
+// 所有传入了ctx的调用者都放弃等待后被取消，fn不会在没有人等待的时候继续运行，也不会因为第一个调用者放弃而影响其他调用者
+type callContext struct {
+	context.Context
+	//发起调用时的key，取消时从Group.calls中删除，之后的调用者重新执行fn
+	keys []string
+
+	mu sync.Mutex
+	//等待者 -> deadline，没有deadline的等待者为零值
+	waiters map[int]time.Time
+	next    int
+	done    chan struct{}
+	err     error
+}
+
+func newCallContext(ctx context.Context, keys []string) *callContext {
+	return &callContext{
+		Context: context.WithoutCancel(ctx),
+		keys:    keys,
+		waiters: make(map[int]time.Time),
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *callContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var latest time.Time
+	for _, deadline := range c.waiters {
+		if deadline.IsZero() {
+			return time.Time{}, false
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+func (c *callContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *callContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// join 在持有Group.mu时调用，记录一个等待者，返回离开时使用的id
+func (c *callContext) join(ctx context.Context) int {
+	deadline, _ := ctx.Deadline()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.next
+	c.next++
+	c.waiters[id] = deadline
+	return id
+}
+
+// leave 在持有Group.mu时调用，最后一个等待者离开时以err取消ctx并返回true
+func (c *callContext) leave(id int, err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.waiters, id)
+	if len(c.waiters) > 0 || c.err != nil {
+		return false
+	}
+	if err == nil {
+		err = context.Canceled
+	}
+	c.err = err
+	close(c.done)
+	return true
 }
 
 type Group struct {
@@ -13,25 +149,268 @@ type Group struct {
 	calls map[string]*call
 }
 
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// Do 同一个key同时只有一个fn在执行，其他调用者等待并共享它的结果，shared表示结果是否被共享
+// fn发生panic时所有调用者都会panic，fn调用runtime.Goexit时所有调用者都会退出
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 	if g.calls == nil {
 		g.calls = make(map[string]*call)
 	}
 	if c, ok := g.calls[key]; ok {
+		c.dups++
 		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+		<-c.done
+		v, err = c.result()
+		return v, err, true
 	}
-	c := new(call)
-	c.wg.Add(1)
+	c := &call{done: make(chan struct{})}
 	g.calls[key] = c
 	g.mu.Unlock()
-	c.val, c.err = fn()
-	c.wg.Done()
 
+	g.doCall(c, key, fn)
+	v, err = c.result()
+	return v, err, c.dups > 0
+}
+
+// DoChan 与Do相同，但是不阻塞，结果会发送到返回的channel中，fn在新的goroutine中执行
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mu.Lock()
-	delete(g.calls, key)
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{done: make(chan struct{}), chans: []chan<- Result{ch}}
+	g.calls[key] = c
 	g.mu.Unlock()
-	return c.val, c.err
+
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// DoContext 与Do相同，但是ctx被取消或超时时会立即返回ctx.Err()
+// fn在新的goroutine中执行，调用者放弃等待后fn会继续执行，其他还在等待的调用者依然能拿到结果
+// 与Do不同，shared只在加入了其他调用者正在执行的fn时为true，第一个调用者总是false，可以直接用来统计被合并的调用
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.dups++
+	} else {
+		c = &call{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.doCall(c, key, fn)
+	}
+	return g.wait(ctx, c, ok)
+}
+
+// DoWithContext 与DoContext相同，但是fn收到一个由所有等待者共同决定的ctx(见callContext)：
+// 它的deadline是还在等待的调用者中最晚的deadline，所有调用者都放弃等待后它被取消，
+// 之后的调用者不会再等待被取消的fn，而是重新执行
+func (g *Group) DoWithContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.dups++
+	} else {
+		c = &call{done: make(chan struct{}), ctx: newCallContext(ctx, []string{key})}
+		g.calls[key] = c
+		go g.doCall(c, key, func() (interface{}, error) {
+			return fn(c.ctx)
+		})
+	}
+	return g.wait(ctx, c, ok)
+}
+
+// wait 在持有g.mu时调用，记录等待者后释放g.mu，等待c的结果或者ctx结束
+func (g *Group) wait(ctx context.Context, c *call, shared bool) (v interface{}, err error, _ bool) {
+	id := g.join(ctx, c)
+	g.mu.Unlock()
+	defer g.leave(c, id, ctx)
+
+	select {
+	case <-c.done:
+		v, err = c.result()
+		return v, err, shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), false
+	}
+}
+
+// join 在持有g.mu时调用，c带有callContext时记录一个等待者
+func (g *Group) join(ctx context.Context, c *call) int {
+	if c.ctx == nil {
+		return 0
+	}
+	return c.ctx.join(ctx)
+}
+
+// leave 等待者离开，最后一个等待者离开时取消fn的ctx，并且让之后的调用者不再等待这次调用
+func (g *Group) leave(c *call, id int, ctx context.Context) {
+	if c.ctx == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !c.ctx.leave(id, ctx.Err()) {
+		return
+	}
+	for _, key := range c.ctx.keys {
+		if other, ok := g.calls[key]; ok && other.ctx == c.ctx {
+			other.forgotten = true
+			delete(g.calls, key)
+		}
+	}
+}
+
+// DoMulti 相当于对每个key分别调用DoWithContext，但是没有正在进行调用的key合并成一次fn(ctx, keys)调用，
+// 已经有调用在进行的key(例如同时进行的Do)等待原来的结果，不会再执行一次。返回的Shared表示等待了其他调用者的结果
+// fn返回的map中没有的key得到ErrNoResult，fn发生panic时它负责的key得到PanicError；ctx被取消时还没有结果的key得到ctx.Err()
+// fn的ctx由它负责的所有key的等待者共同决定，等待其中任何一个key的调用者都会让它继续运行
+func (g *Group) DoMulti(ctx context.Context, keys []string, fn func(ctx context.Context, keys []string) map[string]Result) map[string]Result {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	calls := make(map[string]*call, len(keys))
+	joined := make(map[string]bool)
+	var owned []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if _, ok := g.calls[key]; !ok && !seen[key] {
+			owned = append(owned, key)
+		}
+		seen[key] = true
+	}
+	//todo 负责的key共用一个callContext
+	var shared *callContext
+	if len(owned) > 0 {
+		shared = newCallContext(ctx, owned)
+	}
+	ids := make(map[string]int, len(keys))
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			continue
+		}
+		c, ok := g.calls[key]
+		if ok {
+			c.dups++
+			joined[key] = true
+		} else {
+			c = &call{done: make(chan struct{}), ctx: shared}
+			g.calls[key] = c
+		}
+		calls[key] = c
+		ids[key] = g.join(ctx, c)
+	}
+	g.mu.Unlock()
+
+	if len(owned) > 0 {
+		//todo 每个key的call等待同一次fn调用的结果，其他调用者可以像等待普通的call一样等待它们
+		done := make(chan struct{})
+		var results map[string]Result
+		for _, key := range owned {
+			key := key
+			go g.doCall(calls[key], key, func() (interface{}, error) {
+				<-done
+				if r, ok := results[key]; ok {
+					return r.Val, r.Err
+				}
+				return nil, ErrNoResult
+			})
+		}
+		go func() {
+			defer close(done)
+			defer func() {
+				if r := recover(); r != nil {
+					err := &PanicError{Value: r, Stack: debug.Stack()}
+					results = make(map[string]Result, len(owned))
+					for _, key := range owned {
+						results[key] = Result{Err: err}
+					}
+				}
+			}()
+			results = fn(shared, owned)
+		}()
+	}
+
+	out := make(map[string]Result, len(calls))
+	for key, c := range calls {
+		select {
+		case <-c.done:
+			v, err := c.result()
+			out[key] = Result{Val: v, Err: err, Shared: joined[key]}
+		case <-ctx.Done():
+			out[key] = Result{Err: ctx.Err()}
+		}
+		g.leave(c, ids[key], ctx)
+	}
+	return out
+}
+
+// Forget 让之后对key的调用不再等待正在执行的fn，而是重新执行，例如fn卡住或者结果已经确定过时的时候
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		c.forgotten = true
+		delete(g.calls, key)
+	}
+}
+
+// doCall 执行fn并通知所有等待的调用者
+// 用两层defer区分fn是正常返回、panic还是调用了runtime.Goexit
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+
+	defer func() {
+		//todo 既没有正常返回也没有recover到panic，说明fn调用了runtime.Goexit
+		if !normalReturn && !recovered {
+			c.goexit = true
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if !c.forgotten {
+			delete(g.calls, key)
+		}
+		close(c.done)
+
+		result := Result{Val: c.val, Err: c.err, Shared: c.dups > 0}
+		if c.goexit {
+			result.Err = ErrGoexit
+		}
+		for _, ch := range c.chans {
+			ch <- result
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				if r := recover(); r != nil {
+					c.panicked = true
+					c.err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
 }
@@ -0,0 +1,326 @@
+package singleFlight
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v.(string) != "bar" || err != nil || shared {
+		t.Fatalf("Do v = %v, error = %v, shared = %v", v, err, shared)
+	}
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err, shared := g.Do("key", fn); v.(string) != "bar" || err != nil || !shared {
+				t.Errorf("Do v = %v, error = %v, shared = %v", v, err, shared)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("number of calls = %d; want 1", got)
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFunc()
+	if _, err, _ := g.DoContext(ctx, "key", fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DoContext should return DeadlineExceeded, got %v", err)
+	}
+
+	//todo fn还在执行，后来的调用者应该复用它的结果
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	v, err, _ := g.DoContext(context.Background(), "key", func() (interface{}, error) {
+		return "baz", nil
+	})
+	if v.(string) != "bar" || err != nil {
+		t.Fatalf("DoContext v = %v, error = %v", v, err)
+	}
+}
+
+func TestDoContextShared(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+
+	//todo 只有加入了已有调用的调用者shared为true
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, ok := g.DoContext(context.Background(), "key", fn); ok {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&shared); got != 4 {
+		t.Fatalf("4 callers should share the result, got %d", got)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	chans := make([]<-chan Result, 5)
+	for i := range chans {
+		chans[i] = g.DoChan("key", fn)
+	}
+	close(release)
+	for _, ch := range chans {
+		if res := <-ch; res.Val.(string) != "bar" || res.Err != nil || !res.Shared {
+			t.Fatalf("DoChan result = %+v", res)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("number of calls = %d; want 1", got)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	stuck := make(chan struct{})
+	defer close(stuck)
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-stuck
+		return "stuck", nil
+	})
+
+	//todo Forget之后重新执行，不再等待卡住的fn
+	g.Forget("key")
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "fresh", nil
+	})
+	if v.(string) != "fresh" || err != nil || shared {
+		t.Fatalf("Do after Forget v = %v, error = %v, shared = %v", v, err, shared)
+	}
+
+	select {
+	case res := <-first:
+		t.Fatalf("forgotten call should still be running, got %+v", res)
+	default:
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+
+	var wg sync.WaitGroup
+	var panics int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					if p, ok := r.(*PanicError); ok && p.Value == "boom" {
+						atomic.AddInt32(&panics, 1)
+					}
+				}
+			}()
+			g.Do("key", fn)
+		}()
+	}
+	ch := g.DoChan("key", fn)
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+
+	//todo DoChan的调用者收到PanicError，不会导致进程崩溃
+	res := <-ch
+	var p *PanicError
+	if !errors.As(res.Err, &p) || p.Value != "boom" {
+		t.Fatalf("DoChan should receive the panic, got %+v", res)
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&panics); got != 5 {
+		t.Fatalf("every Do caller should panic, got %d", got)
+	}
+
+	//todo DoContext在新的goroutine中执行fn，panic同样传递给调用者
+	func() {
+		defer func() {
+			if _, ok := recover().(*PanicError); !ok {
+				t.Fatal("DoContext should panic with a PanicError")
+			}
+		}()
+		g.DoContext(context.Background(), "key", func() (interface{}, error) {
+			panic("boom")
+		})
+	}()
+}
+
+func TestGoexit(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		runtime.Goexit()
+		return nil, nil
+	}
+
+	var wg sync.WaitGroup
+	var returned int32
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Do("key", fn)
+			atomic.AddInt32(&returned, 1)
+		}()
+	}
+	ch := g.DoChan("key", fn)
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+
+	if res := <-ch; !errors.Is(res.Err, ErrGoexit) {
+		t.Fatalf("DoChan should receive ErrGoexit, got %+v", res)
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&returned); got != 0 {
+		t.Fatalf("Do callers should exit with the leader, %d returned", got)
+	}
+}
+
+func TestDoMulti(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go g.Do("a", func() (interface{}, error) {
+		close(started)
+		<-release
+		return "a-do", nil
+	})
+	<-started
+
+	var batches [][]string
+	done := make(chan map[string]Result)
+	go func() {
+		done <- g.DoMulti(context.Background(), []string{"a", "b", "c", "b"}, func(ctx context.Context, keys []string) map[string]Result {
+			batches = append(batches, keys)
+			return map[string]Result{"b": {Val: "b-multi"}}
+		})
+	}()
+	//todo a已经在Do中，DoMulti等待它的结果
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	results := <-done
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0] != "b" || batches[0][1] != "c" {
+		t.Fatalf("only b and c should be loaded in one batch, got %v", batches)
+	}
+	if results["a"].Val != "a-do" || !results["a"].Shared {
+		t.Fatalf("a should share the result of Do, got %+v", results["a"])
+	}
+	if results["b"].Val != "b-multi" || results["b"].Shared || !errors.Is(results["c"].Err, ErrNoResult) {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+func TestDoWithContext(t *testing.T) {
+	var g Group
+	deadlines := make(chan time.Time, 1)
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-release
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		return "bar", nil
+	}
+
+	//todo fn的deadline是还在等待的调用者中最晚的deadline，第一个调用者超时不影响第二个调用者
+	first, cancelFirst := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFirst()
+	second, cancelSecond := context.WithTimeout(context.Background(), time.Second)
+	defer cancelSecond()
+	errs := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoWithContext(first, "key", fn)
+		errs <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	time.AfterFunc(40*time.Millisecond, func() { close(release) })
+	v, err, shared := g.DoWithContext(second, "key", fn)
+	if v != "bar" || err != nil || !shared {
+		t.Fatalf("DoWithContext v = %v, error = %v, shared = %v", v, err, shared)
+	}
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("first caller should give up, got %v", err)
+	}
+	if want, _ := second.Deadline(); !(<-deadlines).Equal(want) {
+		t.Fatal("fn should see the second caller's deadline")
+	}
+}
+
+func TestDoWithContextAbandoned(t *testing.T) {
+	var g Group
+	canceled := make(chan error, 1)
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFunc()
+	_, err, _ := g.DoWithContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DoWithContext should return DeadlineExceeded, got %v", err)
+	}
+	//todo 所有调用者都离开后fn的ctx被取消，之后的调用者重新执行fn
+	if err := <-canceled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("fn should be canceled with DeadlineExceeded, got %v", err)
+	}
+	v, err, _ := g.DoWithContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		return "baz", nil
+	})
+	if v != "baz" || err != nil {
+		t.Fatalf("DoWithContext v = %v, error = %v", v, err)
+	}
+}
@@ -0,0 +1,41 @@
+package simpleCache
+
+import "sync/atomic"
+
+// Stats Group的统计信息，所有字段都可以并发读取
+type Stats struct {
+	//所有Get请求
+	Gets atomic.Int64
+	//mainCache命中
+	CacheHits atomic.Int64
+	//过期后在stale-while-revalidate时间内返回旧值
+	StaleHits atomic.Int64
+	//加载失败时返回了过期的旧值
+	StaleErrors atomic.Int64
+	//后台刷新的次数，包括refresh-ahead和stale-while-revalidate
+	Refreshes atomic.Int64
+	//后台刷新失败，不包括key已经不存在
+	RefreshErrors atomic.Int64
+	//hotCache命中，即命中了从其他peer复制来的热点数据
+	HotCacheHits atomic.Int64
+	//命中negativeCache，即已知不存在的key
+	NegativeHits atomic.Int64
+	//从peer成功获取，包括peer确认key不存在
+	PeerLoads atomic.Int64
+	//从peer获取失败
+	PeerErrors atomic.Int64
+	//首选owner失败后从其他副本成功获取，也计入PeerLoads
+	ReplicaLoads atomic.Int64
+	//等待其他调用者正在进行的加载，没有自己加载
+	LoadsDeduped atomic.Int64
+	//调用本地getter
+	LocalLoads atomic.Int64
+	//GetMulti中的批量请求，包括对peer的GetMulti和本地的BatchGetter
+	BatchLoads atomic.Int64
+	//被Bloom filter拦截的不存在的key
+	BloomRejects atomic.Int64
+	//通过了Bloom filter但是key不存在
+	BloomFalsePositives atomic.Int64
+	//Set和Remove之后通知其他peer删除hotCache副本失败
+	InvalidateErrors atomic.Int64
+}
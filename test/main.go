@@ -4,8 +4,12 @@ import (
 	"flag"
 	"fmt"
 	cache "github.com/thewisecirno/simple_distributed_cache"
+	"github.com/thewisecirno/simple_distributed_cache/discovery"
 	"github.com/thewisecirno/simple_distributed_cache/etcd"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
 )
 
 var db = map[string]string{
@@ -28,6 +32,10 @@ var db = map[string]string{
 
 func main() {
 	addr := flag.String("addr", "", "ip:port")
+	peers := flag.String("peers", "", "static peers, e.g. 127.0.0.1:8001,127.0.0.1:8002")
+	peersFile := flag.String("peers-file", "", "json or yaml file listing peers")
+	weight := flag.Int("weight", 1, "weight of this node in the hash ring")
+	debug := flag.Bool("debug", false, "log sampled cache hits and peer requests")
 	//data := flag.String("kv", " ", "db data")
 	flag.Parse()
 
@@ -39,17 +47,31 @@ func main() {
 	//}
 	//log.Println(db)
 
-	cache.NewHTTPPoolWithEtcd(*addr, "", &etcd.ConfigEtcd{
-		EndPoints: []string{"47.115.217.189:2379"},
-	})
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	//todo 指定了peers或peers-file时不依赖etcd，方便本地开发
+	switch {
+	case *peers != "":
+		cache.NewHTTPPool(*addr, "", discovery.NewStatic(strings.Split(*peers, ",")...), cache.WithWeight(*weight), cache.WithHTTPLogger(logger))
+	case *peersFile != "":
+		cache.NewHTTPPool(*addr, "", discovery.NewFile(*peersFile, 0, discovery.WithFileLogger(logger)), cache.WithWeight(*weight), cache.WithHTTPLogger(logger))
+	default:
+		cache.NewHTTPPoolWithEtcd(*addr, "", &etcd.ConfigEtcd{
+			EndPoints: []string{"47.115.217.189:2379"},
+		}, cache.WithWeight(*weight), cache.WithHTTPLogger(logger))
+	}
 	cache.NewGroup("scores", 2<<10, cache.GetterHandler(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s: %w", key, cache.ErrNotFound)
+		}), cache.WithLogger(logger))
 	log.Println("_cache is running at", *addr)
 	cache.Start(*addr)
 }
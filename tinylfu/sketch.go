@@ -0,0 +1,81 @@
+package tinylfu
+
+import "hash/fnv"
+
+const (
+	sketchDepth = 4
+	//计数器最大值，与4bit计数器一致
+	maxCounter = 15
+)
+
+// sketch Count-Min Sketch，用很小的空间近似统计每个key的访问频率
+// 计数总次数达到sampleSize后所有计数器减半，使旧的热点逐渐冷却
+type sketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newSketch(width int) *sketch {
+	width = nextPowerOfTwo(width)
+	s := &sketch{
+		mask:       uint64(width - 1),
+		sampleSize: 10 * width,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *sketch) increment(key string) {
+	h1, h2 := hash(key)
+	for i := range s.rows {
+		idx := uint64(h1+uint32(i)*h2) & s.mask
+		if s.rows[i][idx] < maxCounter {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate 返回所有行中最小的计数
+func (s *sketch) estimate(key string) uint8 {
+	h1, h2 := hash(key)
+	min := uint8(maxCounter)
+	for i := range s.rows {
+		idx := uint64(h1+uint32(i)*h2) & s.mask
+		if s.rows[i][idx] < min {
+			min = s.rows[i][idx]
+		}
+	}
+	return min
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func hash(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum >> 32)
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
@@ -0,0 +1,255 @@
+package tinylfu
+
+import (
+	"container/list"
+	"github.com/thewisecirno/simple_distributed_cache/lru"
+	"time"
+)
+
+// Value 与lru.Value相同，方便不同的淘汰策略互相替换
+type Value = lru.Value
+
+const (
+	//窗口占总容量的百分比
+	windowPercent = 1
+	//protected占主缓存的百分比
+	protectedPercent = 80
+
+	minSketchWidth = 1 << 10
+	maxSketchWidth = 1 << 18
+	//估算sketch宽度时假设的平均记录大小
+	avgEntryBytes = 64
+)
+
+type segment uint8
+
+const (
+	window segment = iota
+	probation
+	protected
+)
+
+// Cache W-TinyLFU
+// 新记录先进入一个很小的LRU窗口，被挤出窗口后作为候选者与主缓存(SLRU)中probation段最旧的记录比较访问频率，
+// 频率更高的才能进入主缓存，因此一次性的扫描流量只能占用窗口，无法冲掉主缓存中的热点
+type Cache struct {
+	maxBytes     int64
+	nowBytes     int64
+	windowMax    int64
+	mainMax      int64
+	protectedMax int64
+
+	lists [3]*list.List
+	bytes [3]int64
+	cache map[string]*list.Element
+
+	sketch *sketch
+
+	//某条记录被删除时的回调函数
+	OnEvicted func(key string, value Value)
+}
+
+type entry struct {
+	key string
+	val Value
+	//过期时间，零值表示永不过期
+	expire time.Time
+	seg    segment
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.val.Len())
+}
+
+func NewCache(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
+	width := int(maxBytes / avgEntryBytes)
+	if width < minSketchWidth {
+		width = minSketchWidth
+	}
+	if width > maxSketchWidth || maxBytes == 0 {
+		width = maxSketchWidth
+	}
+	windowMax := maxBytes * windowPercent / 100
+	mainMax := maxBytes - windowMax
+	return &Cache{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		mainMax:      mainMax,
+		protectedMax: mainMax * protectedPercent / 100,
+		lists:        [3]*list.List{list.New(), list.New(), list.New()},
+		cache:        make(map[string]*list.Element),
+		sketch:       newSketch(width),
+		OnEvicted:    onEvicted,
+	}
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes 当前占用的字节数，包括key和value
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
+func (c *Cache) Add(key string, val Value) {
+	c.AddWithExpire(key, val, time.Time{})
+}
+
+// AddWithExpire 添加一条在expireAt之后过期的记录，expireAt为零值时永不过期
+func (c *Cache) AddWithExpire(key string, val Value, expireAt time.Time) {
+	if element, ok := c.cache[key]; ok {
+		kv := element.Value.(*entry)
+		delta := int64(val.Len()) - int64(kv.val.Len())
+		c.nowBytes += delta
+		c.bytes[kv.seg] += delta
+		kv.val = val
+		kv.expire = expireAt
+		c.lists[kv.seg].MoveToFront(element)
+	} else {
+		c.push(&entry{key: key, val: val, expire: expireAt, seg: window})
+	}
+	c.evict()
+}
+
+// Get 获取key对应的值，无论是否命中都会记录一次访问频率，已经过期的记录会在这里被惰性删除
+func (c *Cache) Get(key string) (val Value, ok bool) {
+	c.sketch.increment(key)
+	if element, ok1 := c.cache[key]; ok1 {
+		kv := element.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(element)
+			return
+		}
+		if kv.seg == probation {
+			//todo probation中再次被访问的记录提升到protected
+			c.unlink(element)
+			kv.seg = protected
+			c.push(kv)
+			c.demote()
+		} else {
+			c.lists[kv.seg].MoveToFront(element)
+		}
+		return kv.val, ok1
+	}
+	return
+}
+
+// RemoveOldest 依次从probation、protected、窗口中淘汰最旧的一条记录
+func (c *Cache) RemoveOldest() {
+	for _, seg := range []segment{probation, protected, window} {
+		if back := c.lists[seg].Back(); back != nil {
+			c.removeElement(back)
+			return
+		}
+	}
+}
+
+// RemoveExpired 遍历并删除所有已经过期的记录，返回删除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+	for _, element := range c.cache {
+		if element.Value.(*entry).expired(now) {
+			c.removeElement(element)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Remove 删除key对应的记录，不会触发OnEvicted
+func (c *Cache) Remove(key string) {
+	if element, ok := c.cache[key]; ok {
+		c.unlink(element)
+	}
+}
+
+// evict 将溢出窗口的记录交给admit决定去留，并保证总大小不超过maxBytes
+func (c *Cache) evict() {
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.bytes[window] > c.windowMax {
+		candidate := c.unlink(c.lists[window].Back())
+		c.admit(candidate)
+	}
+	c.demote()
+	for c.nowBytes > c.maxBytes {
+		c.RemoveOldest()
+	}
+}
+
+// admit 主缓存放不下候选者时，只有候选者的访问频率高于被淘汰者才能进入主缓存
+func (c *Cache) admit(candidate *entry) {
+	size := candidate.size()
+	for c.bytes[probation]+c.bytes[protected]+size > c.mainMax {
+		victim := c.lists[probation].Back()
+		if victim == nil {
+			victim = c.lists[protected].Back()
+		}
+		if victim == nil {
+			break
+		}
+		if c.sketch.estimate(candidate.key) <= c.sketch.estimate(victim.Value.(*entry).key) {
+			if c.OnEvicted != nil {
+				c.OnEvicted(candidate.key, candidate.val)
+			}
+			return
+		}
+		c.removeElement(victim)
+	}
+	candidate.seg = probation
+	c.push(candidate)
+}
+
+// demote protected超出上限时，将最旧的记录降级到probation
+func (c *Cache) demote() {
+	for c.maxBytes != 0 && c.bytes[protected] > c.protectedMax {
+		kv := c.unlink(c.lists[protected].Back())
+		kv.seg = probation
+		c.push(kv)
+	}
+}
+
+func (c *Cache) push(kv *entry) {
+	c.cache[kv.key] = c.lists[kv.seg].PushFront(kv)
+	c.bytes[kv.seg] += kv.size()
+	c.nowBytes += kv.size()
+}
+
+func (c *Cache) unlink(element *list.Element) *entry {
+	kv := element.Value.(*entry)
+	c.lists[kv.seg].Remove(element)
+	c.bytes[kv.seg] -= kv.size()
+	c.nowBytes -= kv.size()
+	delete(c.cache, kv.key)
+	return kv
+}
+
+func (c *Cache) removeElement(element *list.Element) {
+	kv := c.unlink(element)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.val)
+	}
+}
+
+// Hottest 依次返回protected、probation和窗口中的记录，各自从最近访问的开始，最多为n条没有过期的记录调用fn
+func (c *Cache) Hottest(n int, fn func(key string, val Value, expire time.Time)) {
+	now := time.Now()
+	for _, seg := range []segment{protected, probation, window} {
+		for element := c.lists[seg].Front(); element != nil && n > 0; element = element.Next() {
+			kv := element.Value.(*entry)
+			if kv.expired(now) {
+				continue
+			}
+			fn(kv.key, kv.val, kv.expire)
+			n--
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package tinylfu
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+func TestGet(t *testing.T) {
+	lfu := NewCache(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	if v, ok := lfu.Get("key1"); !ok || string(v.(String)) != "1234" {
+		t.Fatalf("cache hit key1=1234 failed")
+	}
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("cache miss key2 failed")
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	evicted := make(map[string]bool)
+	lfu := NewCache(int64(400), func(key string, value Value) {
+		evicted[key] = true
+	})
+	//todo 热点记录被频繁访问后进入protected
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("hot%02d", i)
+		lfu.Get(key)
+		lfu.Add(key, String("vvvvvvvvvvvvv"))
+		for j := 0; j < 5; j++ {
+			lfu.Get(key)
+		}
+	}
+	//todo 扫描流量只访问一次，频率低于热点，无法进入主缓存
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("scan%04d", i)
+		lfu.Get(key)
+		lfu.Add(key, String("vvvvvvvvvvv"))
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("hot%02d", i)
+		if evicted[key] {
+			t.Fatalf("hot key %s should not be evicted by a scan", key)
+		}
+	}
+	if lfu.nowBytes > 400 {
+		t.Fatalf("nowBytes %d exceeds maxBytes", lfu.nowBytes)
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newSketch(16)
+	for i := 0; i < 5; i++ {
+		s.increment("a")
+	}
+	s.increment("b")
+	if s.estimate("a") < 5 || s.estimate("a") <= s.estimate("b") {
+		t.Fatalf("estimate a=%d b=%d", s.estimate("a"), s.estimate("b"))
+	}
+	s.reset()
+	if s.estimate("a") > 3 {
+		t.Fatalf("reset should halve counters, a=%d", s.estimate("a"))
+	}
+}
+
+func TestRemoveAndExpire(t *testing.T) {
+	lfu := NewCache(int64(0), nil)
+	lfu.Add("key1", String("1234"))
+	lfu.AddWithExpire("key2", String("5678"), time.Now().Add(-time.Second))
+	lfu.AddWithExpire("key3", String("abcd"), time.Now().Add(-time.Second))
+	if _, ok := lfu.Get("key2"); ok {
+		t.Fatalf("expired key2 should be removed lazily")
+	}
+	if removed := lfu.RemoveExpired(); removed != 1 {
+		t.Fatalf("RemoveExpired should remove key3, removed %d", removed)
+	}
+	lfu.Remove("key1")
+	if lfu.Len() != 0 || lfu.nowBytes != 0 {
+		t.Fatalf("cache should be empty, len %d bytes %d", lfu.Len(), lfu.nowBytes)
+	}
+}
+
+func TestHottest(t *testing.T) {
+	c := NewCache(int64(1<<10), nil)
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+	c.Add("k3", String("v3"))
+	c.Get("k1")
+	//todo k1再次访问后进入protected
+	keys := make([]string, 0)
+	c.Hottest(2, func(key string, val Value, expire time.Time) {
+		keys = append(keys, key)
+	})
+	if expect := []string{"k1", "k3"}; !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expect hottest keys %s, got %s", expect, keys)
+	}
+}
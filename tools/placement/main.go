@@ -0,0 +1,122 @@
+// placement 对比不同的placement和哈希函数的负载均衡程度，以及结点变化时移动的key的比例
+// 例如 go run ./tools/placement -nodes 10 -keys 100000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/thewisecirno/simple_distributed_cache/consistentHash"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type namedHash struct {
+	name string
+	hash consistentHash.Hash
+}
+
+type namedPlacement struct {
+	name string
+	new  func(fn consistentHash.Hash) consistentHash.Placement
+}
+
+func main() {
+	nodes := flag.Int("nodes", 10, "number of nodes")
+	keys := flag.Int("keys", 100000, "number of keys")
+	replicas := flag.Int("replicas", consistentHash.DefaultReplicas, "virtual nodes per node for the ring")
+	flag.Parse()
+
+	placements := []namedPlacement{
+		{"ring", func(fn consistentHash.Hash) consistentHash.Placement {
+			return consistentHash.NewConsistentHash(*replicas, fn)
+		}},
+		{"rendezvous", func(fn consistentHash.Hash) consistentHash.Placement {
+			return consistentHash.NewRendezvous(fn)
+		}},
+		{"jump", func(fn consistentHash.Hash) consistentHash.Placement {
+			return consistentHash.NewJump(fn)
+		}},
+	}
+	hashes := []namedHash{
+		{"crc32", consistentHash.CRC32},
+		{"xxhash", consistentHash.XXHash},
+		{"murmur3", consistentHash.Murmur3},
+	}
+
+	keyList := make([]string, *keys)
+	for i := range keyList {
+		keyList[i] = "key" + strconv.Itoa(i)
+	}
+
+	fmt.Printf("%d nodes, %d keys, ring replicas %d\n", *nodes, *keys, *replicas)
+	fmt.Printf("ideal movement: add %.4f, remove %.4f\n\n", 1/float64(*nodes+1), 1/float64(*nodes))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "placement\thash\tmax/avg\tstddev/avg\tmoved(add)\tmoved(remove)\tns/get\t")
+	for _, p := range placements {
+		for _, h := range hashes {
+			placement := p.new(h.hash)
+			for i := 0; i < *nodes; i++ {
+				placement.AddWeighted(node(i), 1)
+			}
+
+			start := time.Now()
+			before := owners(placement, keyList)
+			perGet := time.Since(start).Nanoseconds() / int64(len(keyList))
+			maxAvg, stddev := balance(before, *nodes)
+
+			placement.AddWeighted(node(*nodes), 1)
+			added := owners(placement, keyList)
+			placement.Del(node(*nodes))
+			//todo 删除中间的结点，对jump来说是最坏的情况
+			placement.Del(node(0))
+			removed := owners(placement, keyList)
+
+			fmt.Fprintf(w, "%s\t%s\t%.3f\t%.3f\t%.4f\t%.4f\t%d\t\n",
+				p.name, h.name, maxAvg, stddev, moved(before, added), moved(before, removed), perGet)
+		}
+	}
+	_ = w.Flush()
+}
+
+func node(i int) string {
+	return fmt.Sprintf("127.0.0.1:%d", 8000+i)
+}
+
+func owners(p consistentHash.Placement, keys []string) []string {
+	owner := make([]string, len(keys))
+	for i, key := range keys {
+		owner[i] = p.Get(key)
+	}
+	return owner
+}
+
+// balance 返回最大负载与平均负载之比，以及标准差与平均负载之比
+func balance(owner []string, nodes int) (float64, float64) {
+	count := make(map[string]int, nodes)
+	for _, n := range owner {
+		count[n]++
+	}
+	avg := float64(len(owner)) / float64(nodes)
+	max, variance := 0, 0.0
+	for i := 0; i < nodes; i++ {
+		c := count[node(i)]
+		if c > max {
+			max = c
+		}
+		variance += (float64(c) - avg) * (float64(c) - avg)
+	}
+	return float64(max) / avg, math.Sqrt(variance/float64(nodes)) / avg
+}
+
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
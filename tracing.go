@@ -0,0 +1,37 @@
+package simpleCache
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/thewisecirno/simple_distributed_cache"
+
+// propagator peer之间用W3C trace context传递trace
+var propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// startSpan 每次都从全局的TracerProvider获取tracer，没有调用otel.SetTracerProvider时是no-op
+func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.GetTracerProvider().Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// endSpan 记录错误并结束span，ErrNotFound不算错误
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// setKeyAttributes span中与日志一样只记录key的哈希值，没有TracerProvider(span不记录)时不计算哈希，不影响缓存命中的开销
+func setKeyAttributes(span trace.Span, group, key string) {
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("cache.group", group), attribute.String("key_hash", hashKey(key)))
+	}
+}
@@ -0,0 +1,154 @@
+package simpleCache
+
+import (
+	"context"
+	pb "github.com/thewisecirno/simple_distributed_cache/cacheProtobuf"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitSpans 服务端的span在响应写出之后才结束，需要等待
+func waitSpans(t *testing.T, exporter *tracetest.InMemoryExporter, n int) map[string]tracetest.SpanStub {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if spans := exporter.GetSpans(); len(spans) >= n {
+			byName := make(map[string]tracetest.SpanStub)
+			for _, span := range spans {
+				byName[span.Name] = span
+			}
+			return byName
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("want %d spans, got %v", n, exporter.GetSpans())
+	return nil
+}
+
+func attr(span tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	NewGroup("trace-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	server := httptest.NewServer(&HTTPPool{basePath: defaultBasePath})
+	defer server.Close()
+	getter := &HttpGetter{baseURL: server.Listener.Addr().String() + defaultBasePath}
+
+	ctx, root := startSpan(context.Background(), "request")
+	if err := getter.Get(ctx, &pb.Request{Group: "trace-scores", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+
+	//todo 调用方和远端结点的span在同一个trace中，形成一棵树
+	spans := waitSpans(t, exporter, 6)
+	parents := map[string]string{
+		"HttpGetter.Get":     "request",
+		"HTTPPool.ServeHTTP": "HttpGetter.Get",
+		"Group.Get":          "HTTPPool.ServeHTTP",
+		"singleFlight.Do":    "Group.Get",
+		"Getter.Get":         "singleFlight.Do",
+	}
+	traceID := spans["request"].SpanContext.TraceID()
+	for child, parent := range parents {
+		span, ok := spans[child]
+		if !ok {
+			t.Fatalf("missing span %s", child)
+		}
+		if span.SpanContext.TraceID() != traceID {
+			t.Fatalf("%s is in another trace", child)
+		}
+		if span.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Fatalf("%s should be a child of %s", child, parent)
+		}
+	}
+	if !spans["HTTPPool.ServeHTTP"].Parent.IsRemote() || spans["HTTPPool.ServeHTTP"].SpanKind != trace.SpanKindServer {
+		t.Fatal("ServeHTTP should continue the remote trace as a server span")
+	}
+	if attr(spans["Group.Get"], "cache.result") != "miss" || attr(spans["singleFlight.Do"], "singleflight.deduped") != "false" {
+		t.Fatalf("unexpected attributes %v %v", spans["Group.Get"].Attributes, spans["singleFlight.Do"].Attributes)
+	}
+	//todo span中与日志一样只有key的哈希值，http.target也不包含key
+	for _, name := range []string{"HttpGetter.Get", "HTTPPool.ServeHTTP", "Group.Get"} {
+		if attr(spans[name], "key_hash") != hashKey("Tom") {
+			t.Fatalf("%s should record the key hash, got %v", name, spans[name].Attributes)
+		}
+	}
+	if target := attr(spans["HTTPPool.ServeHTTP"], "http.target"); target != defaultBasePath+"trace-scores/" {
+		t.Fatalf("http.target should not contain the key, got %s", target)
+	}
+	for name, span := range spans {
+		for _, kv := range span.Attributes {
+			if strings.Contains(kv.Value.Emit(), "Tom") {
+				t.Fatalf("%s leaks the key in %s", name, kv.Key)
+			}
+		}
+	}
+
+	//todo 第二次命中缓存，不再调用getter
+	exporter.Reset()
+	if err := getter.Get(context.Background(), &pb.Request{Group: "trace-scores", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	//todo 命中时只有Group.Get一个span，命中的结果记录在它的属性中
+	spans = waitSpans(t, exporter, 3)
+	if attr(spans["Group.Get"], "cache.result") != "hit" {
+		t.Fatalf("second request should hit the cache, got %v", spans["Group.Get"].Attributes)
+	}
+	if _, ok := spans["Getter.Get"]; ok {
+		t.Fatal("getter should not be called on a cache hit")
+	}
+}
+
+func TestTracingGRPC(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	NewGroup("grpc-trace-scores", 2<<10, GetterHandler(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewGRPCPool(lis.Addr().String())
+	defer resetPool()
+	go func() {
+		_ = pool.Serve(lis)
+	}()
+	defer pool.Stop()
+	getter, err := NewGrpcGetter(lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer getter.Close()
+
+	ctx, root := startSpan(context.Background(), "request")
+	if err := getter.Get(ctx, &pb.Request{Group: "grpc-trace-scores", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+	spans := waitSpans(t, exporter, 6)
+	if spans["Group.Get"].SpanContext.TraceID() != spans["request"].SpanContext.TraceID() {
+		t.Fatal("the remote Group.Get should be in the caller's trace")
+	}
+}